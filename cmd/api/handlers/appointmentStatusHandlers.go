@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+)
+
+// ConfirmAppointmentHandler handles POST /v1/appointments/:id/confirm
+func (h *Handler) ConfirmAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	h.changeAppointmentStatus(w, r, data.AppointmentStatusConfirmed)
+}
+
+// CancelAppointmentHandler handles POST /v1/appointments/:id/cancel
+func (h *Handler) CancelAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	h.changeAppointmentStatus(w, r, data.AppointmentStatusCancelled)
+}
+
+// CompleteAppointmentHandler handles POST /v1/appointments/:id/complete
+func (h *Handler) CompleteAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	h.changeAppointmentStatus(w, r, data.AppointmentStatusCompleted)
+}
+
+// NoShowAppointmentHandler handles POST /v1/appointments/:id/no-show
+func (h *Handler) NoShowAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	h.changeAppointmentStatus(w, r, data.AppointmentStatusNoShow)
+}
+
+// changeAppointmentStatus moves an appointment to the next status. Only the
+// business side may confirm, complete or mark a no-show, while either the
+// customer or the business may cancel.
+func (h *Handler) changeAppointmentStatus(w http.ResponseWriter, r *http.Request, next data.AppointmentStatus) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	currentUser := h.contextGetUser(r)
+
+	appointment, err := h.models.Appointments.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	allowed := h.models.Appointments.IsBusinessSide(currentUser, h.contextGetPermissions(r), appointment)
+	if next == data.AppointmentStatusCancelled {
+		allowed = h.models.Appointments.CanAccessAppointmentData(currentUser, h.contextGetPermissions(r), appointment)
+	}
+
+	if !allowed {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	// confirmations and cancellations update both sides' calendars
+	var emails []*data.QueuedEmail
+	changed := *appointment
+	changed.Status = next
+	changed.Sequence++
+	switch next {
+	case data.AppointmentStatusConfirmed:
+		emails = appointmentEmails(&changed, "appointment_confirmed.tmpl", nil)
+	case data.AppointmentStatusCancelled:
+		emails = appointmentEmails(&changed, "appointment_cancelled.tmpl", map[string]any{
+			"byBusiness": h.models.Appointments.IsBusinessSide(currentUser, h.contextGetPermissions(r), appointment),
+		})
+	}
+
+	from := appointment.Status
+	err = h.models.Appointments.UpdateStatus(appointment, next, currentUser.ID, emails...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidTransition):
+			h.invalidTransitionResponse(w, r, from, next)
+		case errors.Is(err, data.ErrEditConflict):
+			h.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrSlotTaken):
+			h.slotTakenResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"appointment": appointment}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// GetAppointmentHistoryHandler handles GET /v1/appointments/:id/history
+func (h *Handler) GetAppointmentHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	currentUser := h.contextGetUser(r)
+
+	appointment, err := h.models.Appointments.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !h.models.Appointments.CanAccessAppointmentData(currentUser, h.contextGetPermissions(r), appointment) {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	history, err := h.models.Appointments.GetStatusHistory(appointment.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"history": history}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,54 +1,639 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"maps"
 	"net/http"
+	"time"
 
 	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/availability"
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/ical"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/mailer"
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
 )
 
-func (h *Handler) GetAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
-	appointments := utils.Envelope{"appointments": []utils.Envelope{{"id": 1, "title": "Doctor's Appointment", "date": "2024-07-01T10:00:00Z"},{"id": 2, "title": "Meeting with Bob", "date": "2024-07-02T14:00:00Z"}}}
-	utils.WriteJSON(w, http.StatusOK, appointments, nil)
-}
-
 func (h *Handler) CreateAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	// the customer booking the appointment is the current user
+	currentUser := h.contextGetUser(r)
+
 	var input struct {
-		Title string `json:"title"`
-		Date  string `json:"date"`
+		BusinessID      int       `json:"business_id"`
+		ServiceID       int       `json:"service_id"`
+		BusinessStaffID int       `json:"business_staff_id"`
+		StartTime       time.Time `json:"start_time"`
+		Name            string    `json:"name"`
+		Notes           string    `json:"notes"`
 	}
 
 	err := utils.ReadJSON(w, r, &input)
 	if err != nil {
-		errorData := utils.Envelope{"error": err.Error()}
-		utils.WriteJSON(w, http.StatusBadRequest, errorData, nil)
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.ServiceID > 0, "service_id", "must be provided")
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the service decides how long the appointment lasts
+	service, err := h.models.Services.Get(input.ServiceID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("service_id", "service does not exist")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// make sure the service is actually offered by the business
+	if service.BusinessID != input.BusinessID {
+		v.AddError("service_id", "service does not belong to this business")
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// suspended and inactive businesses don't take bookings
+	business, err := h.models.Businesses.Get(service.BusinessID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"message": "Appointment created successfully"}, nil)
+	if !business.IsOpen() {
+		v.AddError("business_id", "this business is not taking bookings")
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Either book with the requested staff member or with whoever can perform
+	// the service
+	candidates, err := h.appointmentCandidates(v, input.BusinessStaffID, service)
 	if err != nil {
-		h.Logger.Error("Failed to write JSON response", "error", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "Failed to create appointment"}, nil)
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	appointment := &data.Appointment{
+		BusinessID:       input.BusinessID,
+		BusinessName:     business.Name,
+		BusinessEmail:    business.Email,
+		BusinessTimezone: business.Timezone,
+		ServiceID:        service.ID,
+		ServiceName:      service.Name,
+		CustomerID:       currentUser.ID,
+		CustomerName:     currentUser.Username,
+		CustomerEmail:    currentUser.Email,
+		Name:             input.Name,
+		Notes:            input.Notes,
+		StartTime:        input.StartTime,
+		EndTime:          input.StartTime.Add(time.Duration(service.Duration) * time.Minute),
+		Status:           data.AppointmentStatusPending, // default status for new appointments
+		UID:              data.NewAppointmentUID(),
+	}
+
+	v.Check(appointment.StartTime.After(time.Now()), "start_time", "must be in the future")
+	if data.ValidateAppointment(v, appointment); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// only staff members who work at that time and aren't booked yet can
+	// take the appointment
+	free, err := h.freeCalendars(business, service, candidates, appointment)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(free) == 0 {
+		v.AddError("start_time", "is not available, please choose another time")
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// both sides hear about the booking once it's saved
+	emails := appointmentEmails(appointment, "appointment_booked.tmpl", nil)
+
+	// the first staff member whose calendar is free gets the appointment,
+	// the database has the final say in case someone else just booked it
+	for _, staffID := range free {
+		appointment.BusinessStaffID = staffID
+		_, err = h.models.Appointments.Insert(appointment, emails...)
+		if !errors.Is(err, data.ErrSlotTaken) {
+			break
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrSlotTaken):
+			h.slotTakenResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// re-read the appointment so the response has the business/service names
+	appointment, err = h.models.Appointments.Get(appointment.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/appointments/%d", appointment.ID))
+
+	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"appointment": appointment}, headers)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
 	}
 }
 
+// appointmentCandidates returns the calendars an appointment for the service
+// can go on: the requested staff member, or whoever can perform the service.
+// Businesses without staff take bookings themselves (0). A staff member who
+// can't take the appointment adds a validation error.
+func (h *Handler) appointmentCandidates(v *validator.Validator, staffID int, service *data.Service) ([]int, error) {
+	if staffID != 0 {
+		err := h.checkAppointmentStaff(v, staffID, service)
+		if err != nil {
+			return nil, err
+		}
+		return []int{staffID}, nil
+	}
+
+	staffList, err := h.models.Staff.GetAllForService(service.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(staffList) == 0 {
+		return []int{0}, nil
+	}
+
+	candidates := []int{}
+	for _, staff := range staffList {
+		candidates = append(candidates, staff.ID)
+	}
+	return candidates, nil
+}
+
+// freeCalendars returns the calendars out of candidates on which the service
+// can be booked for the appointment. The appointment's current booking
+// doesn't count, so it can be moved to a time that overlaps it.
+func (h *Handler) freeCalendars(business *data.Business, service *data.Service, candidates []int, appointment *data.Appointment) ([]int, error) {
+	start := appointment.StartTime
+	end := start.Add(time.Duration(service.Duration+service.DownTime) * time.Minute)
+
+	req, err := h.businessCalendar(business, service, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	free := []int{}
+	for _, staffID := range candidates {
+		calendar, err := h.staffCalendar(req, business.ID, staffID, appointment.ID)
+		if err != nil {
+			return nil, err
+		}
+		if availability.Free(calendar, start) {
+			free = append(free, staffID)
+		}
+	}
+
+	return free, nil
+}
+
 func (h *Handler) GetAllAppointmentsHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
+		CustomerID int
+		BusinessID int
 		data.Filters
 	}
 
+	currentUser := h.contextGetUser(r)
+
 	v := validator.New()
 
 	qs := r.URL.Query()
 
+	input.CustomerID = utils.GetSingleIntegerParameter(qs, "customer_id", 0, v)
+	input.BusinessID = utils.GetSingleIntegerParameter(qs, "business_id", 0, v)
+
 	input.Filters.Page = utils.GetSingleIntegerParameter(qs, "page", 1, v)
 	input.Filters.PageSize = utils.GetSingleIntegerParameter(qs, "page_size", 20, v)
 	input.Filters.Sort = utils.GetSingleQueryParameter(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "date", "-id", "-title", "-date"}
+	input.Filters.SortSafelist = []string{"id", "start_time", "status", "created_at", "-id", "-start_time", "-status", "-created_at"}
 
 	if data.ValidateFilters(v, input.Filters); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// businesses:manage sees everything. Business owners can see the appointments of
+	// their business, everyone else only sees their own bookings.
+	if !h.contextGetPermissions(r).Include("businesses:manage") {
+		if input.BusinessID != 0 {
+			canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), input.BusinessID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					h.notFoundResponse(w, r)
+				default:
+					h.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			if !canAccess {
+				h.notPermittedResponse(w, r)
+				return
+			}
+		} else {
+			input.CustomerID = currentUser.ID
+		}
+	}
+
+	appointments, metadata, err := h.models.Appointments.GetAll(input.CustomerID, input.BusinessID, input.Filters)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"appointments": appointments, "metadata": metadata}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) GetAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	currentUser := h.contextGetUser(r)
+
+	appointment, err := h.models.Appointments.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !h.models.Appointments.CanAccessAppointmentData(currentUser, h.contextGetPermissions(r), appointment) {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"appointment": appointment}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) UpdateAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	currentUser := h.contextGetUser(r)
+
+	appointment, err := h.models.Appointments.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !h.models.Appointments.CanAccessAppointmentData(currentUser, h.contextGetPermissions(r), appointment) {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	// finished appointments can't be changed any more
+	if appointment.Status.IsFinal() {
+		h.appointmentFinalResponse(w, r, appointment.Status)
+		return
+	}
+
+	previousStart, previousEnd := appointment.StartTime, appointment.EndTime
+
+	var input struct {
+		ServiceID       *int       `json:"service_id"`
+		BusinessStaffID *int       `json:"business_staff_id"`
+		StartTime       *time.Time `json:"start_time"`
+		Name            *string    `json:"name"`
+		Notes           *string    `json:"notes"`
+	}
+
+	err = utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if input.ServiceID != nil {
+		appointment.ServiceID = *input.ServiceID
+	}
+	if input.BusinessStaffID != nil {
+		appointment.BusinessStaffID = *input.BusinessStaffID
+	}
+	if input.StartTime != nil {
+		appointment.StartTime = *input.StartTime
+	}
+	if input.Name != nil {
+		appointment.Name = *input.Name
+	}
+	if input.Notes != nil {
+		appointment.Notes = *input.Notes
+	}
+
+	// a new service, staff member or start time is a new booking: the end
+	// time has to be recalculated and the calendar it lands on has to be free
+	if input.ServiceID != nil || input.StartTime != nil || input.BusinessStaffID != nil {
+		service, err := h.models.Services.Get(appointment.ServiceID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("service_id", "service does not exist")
 				h.failedValidationResponse(w, r, v.Errors)
+			default:
+				h.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if service.BusinessID != appointment.BusinessID {
+			v.AddError("service_id", "service does not belong to this business")
+			h.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		// suspended and inactive businesses don't take bookings
+		business, err := h.models.Businesses.Get(appointment.BusinessID)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !business.IsOpen() {
+			v.AddError("business_id", "this business is not taking bookings")
+			h.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		candidates, err := h.appointmentCandidates(v, appointment.BusinessStaffID, service)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+
+		appointment.ServiceName = service.Name
+		appointment.EndTime = appointment.StartTime.Add(time.Duration(service.Duration) * time.Minute)
+
+		v.Check(appointment.StartTime.After(time.Now()), "start_time", "must be in the future")
+		if data.ValidateAppointment(v, appointment); !v.IsEmpty() {
+			h.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		free, err := h.freeCalendars(business, service, candidates, appointment)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+		if len(free) == 0 {
+			v.AddError("start_time", "is not available, please choose another time")
+			h.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		// without a staff member asked for, the first one who is free gets it
+		appointment.BusinessStaffID = free[0]
+	}
+
+	if data.ValidateAppointment(v, appointment); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// a new time means new invites for both sides
+	var emails []*data.QueuedEmail
+	if !appointment.StartTime.Equal(previousStart) || !appointment.EndTime.Equal(previousEnd) {
+		rescheduled := *appointment
+		rescheduled.Sequence++
+		emails = appointmentEmails(&rescheduled, "appointment_rescheduled.tmpl", map[string]any{
+			"previousStartTime": previousStart.In(appointment.Location()).Format(appointmentTimeLayout),
+		})
+	}
+
+	err = h.models.Appointments.Update(appointment, emails...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			h.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrSlotTaken):
+			h.slotTakenResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// re-read the appointment so the response has the new service/staff names
+	appointment, err = h.models.Appointments.Get(appointment.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"appointment": appointment}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+func (h *Handler) DeleteAppointmentHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	currentUser := h.contextGetUser(r)
+
+	appointment, err := h.models.Appointments.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !h.models.Appointments.CanAccessAppointmentData(currentUser, h.contextGetPermissions(r), appointment) {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	// customers cancel, which keeps the status history
+	if !h.models.Appointments.IsBusinessSide(currentUser, h.contextGetPermissions(r), appointment) {
+		h.cancelInsteadResponse(w, r)
+		return
+	}
+
+	// finished appointments and their reviews stay on record
+	if appointment.Status.IsFinal() {
+		h.appointmentFinalResponse(w, r, appointment.Status)
+		return
+	}
+
+	// the appointment disappears from both sides' calendars like a
+	// cancellation by the business
+	cancelled := *appointment
+	cancelled.Status = data.AppointmentStatusCancelled
+	cancelled.Sequence++
+	emails := appointmentEmails(&cancelled, "appointment_cancelled.tmpl", map[string]any{
+		"byBusiness": true,
+	})
+
+	err = h.models.Appointments.Delete(appointment, emails...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			h.editConflictResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-}
\ No newline at end of file
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "appointment successfully deleted"}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkAppointmentStaff adds a validation error unless the staff member works
+// for the business offering the service and can perform it
+func (h *Handler) checkAppointmentStaff(v *validator.Validator, staffID int, service *data.Service) error {
+	staff, err := h.models.Staff.Get(staffID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v.AddError("business_staff_id", "staff member does not exist")
+			return nil
+		}
+		return err
+	}
+
+	if staff.BusinessID != service.BusinessID || !staff.CanPerform(service.ID) {
+		v.AddError("business_staff_id", "staff member does not perform this service")
+	}
+	return nil
+}
+
+// appointmentTimeLayout is how appointment times read in emails, in the
+// time zone of the business
+const appointmentTimeLayout = "Monday, January 2 2006 at 15:04 MST"
+
+// appointmentEmails builds the emails the customer and the business get about
+// an appointment. Each one carries an invite that updates their calendar, so
+// the appointment has to have the status and calendar sequence it will have
+// once the change is saved. vars are added to the template data of both.
+func appointmentEmails(appointment *data.Appointment, templateFile string, vars map[string]any) []*data.QueuedEmail {
+	event := ical.Event{
+		UID:           appointment.UID,
+		Sequence:      appointment.Sequence,
+		Method:        ical.MethodRequest,
+		Status:        ical.StatusTentative,
+		Start:         appointment.StartTime,
+		End:           appointment.EndTime,
+		Summary:       fmt.Sprintf("%s at %s", appointment.ServiceName, appointment.BusinessName),
+		Description:   appointment.Notes,
+		OrganizerName: appointment.BusinessName,
+		OrganizerMail: appointment.BusinessEmail,
+		AttendeeName:  appointment.CustomerName,
+		AttendeeMail:  appointment.CustomerEmail,
+	}
+
+	switch appointment.Status {
+	case data.AppointmentStatusConfirmed:
+		event.Status = ical.StatusConfirmed
+	case data.AppointmentStatusCancelled:
+		event.Method = ical.MethodCancel
+		event.Status = ical.StatusCancelled
+	}
+
+	invite := mailer.Attachment{
+		Filename:    "invite.ics",
+		ContentType: event.ContentType(),
+		Content:     event.Marshal(),
+	}
+
+	recipients := []struct {
+		email       string
+		name        string
+		forBusiness bool
+	}{
+		{appointment.CustomerEmail, appointment.CustomerName, false},
+		{appointment.BusinessEmail, appointment.BusinessName, true},
+	}
+
+	emails := []*data.QueuedEmail{}
+	for _, recipient := range recipients {
+		if recipient.email == "" {
+			continue
+		}
+
+		templateData := map[string]any{
+			"username":     recipient.name,
+			"forBusiness":  recipient.forBusiness,
+			"customerName": appointment.CustomerName,
+			"businessName": appointment.BusinessName,
+			"serviceName":  appointment.ServiceName,
+			"startTime":    appointment.StartTime.In(appointment.Location()).Format(appointmentTimeLayout),
+		}
+		maps.Copy(templateData, vars)
+
+		emails = append(emails, &data.QueuedEmail{
+			Recipient:   recipient.email,
+			Template:    templateFile,
+			Data:        templateData,
+			Attachments: []mailer.Attachment{invite},
+		})
+	}
+
+	return emails
+}
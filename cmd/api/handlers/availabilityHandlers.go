@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/availability"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// the longest period a single availability request may cover
+const maxAvailabilityRange = 31 * 24 * time.Hour
+
+// GetAvailabilityHandler handles GET /v1/businesses/:id/availability
+func (h *Handler) GetAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ServiceID int
+		StaffID   int
+		From      time.Time
+		To        time.Time
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	now := time.Now()
+	input.ServiceID = utils.GetSingleIntegerParameter(qs, "service_id", 0, v)
+	input.StaffID = utils.GetSingleIntegerParameter(qs, "staff_id", 0, v)
+	input.From = utils.GetSingleTimeParameter(qs, "from", now, v)
+	input.To = utils.GetSingleTimeParameter(qs, "to", input.From.Add(7*24*time.Hour), v)
+
+	v.Check(input.ServiceID > 0, "service_id", "must be provided")
+	v.Check(input.To.After(input.From), "to", "must be after from")
+	v.Check(input.To.Sub(input.From) <= maxAvailabilityRange, "to", "must be at most 31 days after from")
+
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// slots in the past can't be booked
+	if input.From.Before(now) {
+		input.From = now.In(input.From.Location())
+	}
+
+	business, err := h.models.Businesses.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// businesses that aren't open are only visible to the business side
+	if !business.IsOpen() {
+		canAccess, err := h.models.Businesses.CanAccessBusinessData(h.contextGetUser(r), h.contextGetPermissions(r), business.ID)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+		if !canAccess {
+			h.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	service, err := h.models.Services.Get(input.ServiceID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("service_id", "service does not exist")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if service.BusinessID != business.ID {
+		v.AddError("service_id", "service does not belong to this business")
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if service.Duration <= 0 {
+		v.AddError("service_id", "service does not have a duration")
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	req, err := h.businessCalendar(business, service, input.From, input.To)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Every staff member has their own calendar. Businesses without staff for
+	// the service take bookings on the business calendar (staff ID 0).
+	calendars := []int{0}
+	if input.StaffID != 0 {
+		staff, err := h.models.Staff.Get(input.StaffID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("staff_id", "staff member does not exist")
+				h.failedValidationResponse(w, r, v.Errors)
+			default:
+				h.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if staff.BusinessID != service.BusinessID || !staff.CanPerform(service.ID) {
+			v.AddError("staff_id", "staff member does not perform this service")
+			h.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		calendars = []int{staff.ID}
+	} else {
+		staffList, err := h.models.Staff.GetAllForService(service.ID)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+		if len(staffList) > 0 {
+			calendars = calendars[:0]
+			for _, staff := range staffList {
+				calendars = append(calendars, staff.ID)
+			}
+		}
+	}
+
+	byStaff := map[int][]availability.Slot{}
+	for _, staffID := range calendars {
+		calendar, err := h.staffCalendar(req, business.ID, staffID, 0)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+
+		byStaff[staffID] = availability.Compute(calendar)
+	}
+
+	slots := byStaff[0]
+	if calendars[0] != 0 {
+		slots = availability.Merge(byStaff)
+	}
+
+	response := utils.Envelope{
+		"business_id": service.BusinessID,
+		"service_id":  service.ID,
+		"from":        input.From,
+		"to":          input.To,
+		"slots":       slots,
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// businessCalendar returns the availability request for a service of the
+// business between from and to, with the opening hours and time off of the
+// business. Every calendar of the business starts out from it.
+func (h *Handler) businessCalendar(business *data.Business, service *data.Service, from, to time.Time) (availability.Request, error) {
+	req := availability.Request{
+		From:     from,
+		To:       to,
+		Duration: time.Duration(service.Duration) * time.Minute,
+		Downtime: time.Duration(service.DownTime) * time.Minute,
+		Location: business.Location(),
+	}
+
+	businessHours, err := h.models.BusinessHours.GetAllForBusiness(business.ID)
+	if err != nil {
+		return req, err
+	}
+
+	for _, bh := range businessHours {
+		hours, err := weeklyHours(bh.DayOfWeek, bh.StartTime, bh.EndTime)
+		if err != nil {
+			return req, err
+		}
+		req.Hours = append(req.Hours, hours)
+	}
+
+	timeOff, err := h.models.TimeOff.GetAllInRange(business.ID, from, to)
+	if err != nil {
+		return req, err
+	}
+
+	for _, off := range timeOff {
+		req.Busy = append(req.Busy, availability.Interval{Start: off.StartTime, End: off.EndTime})
+	}
+
+	return req, nil
+}
+
+// staffCalendar narrows the business calendar down to a single calendar:
+// the appointments booked on it, and for staff members their own hours and
+// time off. staffID 0 is the calendar of the business itself. The
+// appointment exceptID doesn't count as booked.
+func (h *Handler) staffCalendar(req availability.Request, businessID int, staffID int, exceptID int) (availability.Request, error) {
+	calendar := req
+	calendar.Busy = slices.Clone(req.Busy)
+
+	booked, err := h.models.Appointments.GetBookedRanges(businessID, staffID, req.From, req.To, exceptID)
+	if err != nil {
+		return calendar, err
+	}
+	for _, b := range booked {
+		calendar.Busy = append(calendar.Busy, availability.Interval{Start: b.Start, End: b.End})
+	}
+
+	if staffID == 0 {
+		return calendar, nil
+	}
+
+	// staff members only work their own hours, and only while the business
+	// is open. Without a schedule they work all opening hours.
+	schedule, err := h.models.StaffSchedule.GetAllForStaff(staffID)
+	if err != nil {
+		return calendar, err
+	}
+	if len(schedule) > 0 {
+		staffHours := []availability.Hours{}
+		for _, sh := range schedule {
+			hours, err := weeklyHours(sh.DayOfWeek, sh.StartTime, sh.EndTime)
+			if err != nil {
+				return calendar, err
+			}
+			staffHours = append(staffHours, hours)
+		}
+		calendar.Hours = availability.Intersect(req.Hours, staffHours)
+	}
+
+	staffTimeOff, err := h.models.StaffTimeOff.GetAllInRange(staffID, req.From, req.To)
+	if err != nil {
+		return calendar, err
+	}
+	for _, off := range staffTimeOff {
+		calendar.Busy = append(calendar.Busy, availability.Interval{Start: off.StartTime, End: off.EndTime})
+	}
+
+	return calendar, nil
+}
+
+// weeklyHours converts a day of the week and HH:MM start and end times into
+// opening hours the availability engine understands
+func weeklyHours(dayOfWeek int, startTime, endTime string) (availability.Hours, error) {
+	start, err := data.ParseClock(startTime)
+	if err != nil {
+		return availability.Hours{}, err
+	}
+	end, err := data.ParseClock(endTime)
+	if err != nil {
+		return availability.Hours{}, err
+	}
+	return availability.Hours{Weekday: time.Weekday(dayOfWeek), Start: start, End: end}, nil
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// GetBusinessHoursHandler handles GET /v1/businesses/:id/hours
+func (h *Handler) GetBusinessHoursHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	// make sure the business exists
+	business, err := h.models.Businesses.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	hours, err := h.models.BusinessHours.GetAllForBusiness(business.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"hours": hours}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// ReplaceBusinessHoursHandler handles PUT /v1/businesses/:id/hours
+func (h *Handler) ReplaceBusinessHoursHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	// get the current user
+	currentUser := h.contextGetUser(r)
+
+	// Check if the current user can access this Business' data
+	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canAccess {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Hours []struct {
+			DayOfWeek      int    `json:"day_of_week"`
+			StartTime      string `json:"start_time"`
+			EndTime        string `json:"end_time"`
+			ClosedForLunch bool   `json:"closed_for_lunch"`
+		} `json:"hours"`
+	}
+
+	err = utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	// an empty list is allowed and clears the schedule
+	schedule := []*data.BusinessHours{}
+	for _, in := range input.Hours {
+		schedule = append(schedule, &data.BusinessHours{
+			BusinessID:     int(id),
+			DayOfWeek:      in.DayOfWeek,
+			StartTime:      in.StartTime,
+			EndTime:        in.EndTime,
+			ClosedForLunch: in.ClosedForLunch,
+		})
+	}
+
+	v := validator.New()
+	v.Check(input.Hours != nil, "hours", "must be provided")
+	if data.ValidateBusinessHours(v, schedule); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = h.models.BusinessHours.ReplaceForBusiness(int(id), schedule)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	hours, err := h.models.BusinessHours.GetAllForBusiness(int(id))
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"hours": hours}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"errors"
 	"net/http"
+	"slices"
 
 	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
@@ -18,6 +19,7 @@ func (h *Handler) CreateBusinessHandler(w http.ResponseWriter, r *http.Request)
 		Bio 	  string `json:"bio"`
 		Email 	  string `json:"email"`
 		Phone 	  string `json:"phone"`
+		Timezone  string `json:"timezone"`
 	}
 
 	err := utils.ReadJSON(w, r, &clientData)
@@ -35,7 +37,10 @@ func (h *Handler) CreateBusinessHandler(w http.ResponseWriter, r *http.Request)
 		OwnerID: currentUser.ID,
 		Status: data.BusinessStatusActive, // default status for new businesses
 		LogoURL: "https://via.placeholder.com/150", // set temp logo_url until we implement file uploads
-
+		Timezone: clientData.Timezone,
+	}
+	if Business.Timezone == "" {
+		Business.Timezone = "UTC"
 	}
 
 	// generate a unique slug for the Business based on its name
@@ -73,15 +78,11 @@ func (h *Handler) CreateBusinessHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// update the user's role to business owner if they aren't already
-	if currentUser.RoleID == 3 {
-		currentUser.RoleID = 2 // set role to business owner
-		_, err = h.models.Users.Update(currentUser)
-		if err != nil {
-			h.Logger.Error("failed to update user role", "error", err)
-			h.serverErrorResponse(w, r, err)
-			return
-		}
+	err = h.grantOwnerPermissions(r, currentUser)
+	if err != nil {
+		h.Logger.Error("failed to grant business owner permissions", "error", err)
+		h.serverErrorResponse(w, r, err)
+		return
 	}
 
 	response := utils.Envelope{"business": Business}
@@ -91,8 +92,37 @@ func (h *Handler) CreateBusinessHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// grantOwnerPermissions makes sure the owner of a new business holds the
+// permissions of the business role. Users that hold nothing beyond that role
+// move to it, anyone else keeps their role and gets the missing permissions
+// on top of it.
+func (h *Handler) grantOwnerPermissions(r *http.Request, user *data.User) error {
+	role, err := h.models.Roles.GetByName(data.RoleBusiness)
+	if err != nil {
+		return err
+	}
+
+	ownerPermissions, err := h.models.Permissions.GetAllForRole(role.ID)
+	if err != nil {
+		return err
+	}
+
+	permissions := h.contextGetPermissions(r)
+	missing := permissions.Missing(ownerPermissions)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if len(ownerPermissions.Missing(permissions)) == 0 {
+		return h.models.Users.UpdateRole(user, role.ID)
+	}
+
+	return h.models.Permissions.AddForUser(user.ID, missing)
+}
+
 func (h *Handler) GetAllBusinessesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
+		Status data.BusinessStatus
 		data.Filters
 	}
 
@@ -100,6 +130,14 @@ func (h *Handler) GetAllBusinessesHandler(w http.ResponseWriter, r *http.Request
 
 	qs := r.URL.Query()
 
+	// only moderators get to see businesses that aren't open
+	input.Status = data.BusinessStatusActive
+	if h.contextGetPermissions(r).Include("businesses:moderate") {
+		input.Status = data.BusinessStatus(utils.GetSingleQueryParameter(qs, "status", ""))
+		v.Check(validator.PermittedValue(string(input.Status), "", string(data.BusinessStatusActive), string(data.BusinessStatusInactive), string(data.BusinessStatusSuspended)),
+			"status", "must be active, inactive or suspended")
+	}
+
 	input.Filters.Page = utils.GetSingleIntegerParameter(qs, "page", 1, v)
 	input.Filters.PageSize = utils.GetSingleIntegerParameter(qs, "page_size", 20, v)
 	input.Filters.Sort = utils.GetSingleQueryParameter(qs, "sort", "id")
@@ -110,7 +148,7 @@ func (h *Handler) GetAllBusinessesHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	businesses, metadata, err := h.models.Businesses.GetAll(input.Filters)
+	businesses, metadata, err := h.models.Businesses.GetAll(input.Status, input.Filters)
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 		return
@@ -142,6 +180,19 @@ func (h *Handler) GetBusinessHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// businesses that aren't open are only visible to the business side
+	if !business.IsOpen() {
+		canAccess, err := h.models.Businesses.CanAccessBusinessData(h.contextGetUser(r), h.contextGetPermissions(r), business.ID)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+		if !canAccess {
+			h.notFoundResponse(w, r)
+			return
+		}
+	}
+
 	response := utils.Envelope{
 		"business": business,
 	}
@@ -163,7 +214,7 @@ func (h *Handler) UpdateBusinessHandler(w http.ResponseWriter, r *http.Request)
 	currentUser := h.contextGetUser(r)
 
 	// Check if the current user can access this Business' data
-	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, int(id))
+	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), int(id))
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 		return
@@ -192,6 +243,8 @@ func (h *Handler) UpdateBusinessHandler(w http.ResponseWriter, r *http.Request)
 		Bio 	  *string `json:"bio"`
 		Email 	  *string `json:"email"`
 		Phone 	  *string `json:"phone"`
+		ReminderOffsets *[]int64 `json:"reminder_offsets"`
+		Timezone *string `json:"timezone"`
 	}
 
 	err = utils.ReadJSON(w, r, &clientData)
@@ -213,6 +266,15 @@ func (h *Handler) UpdateBusinessHandler(w http.ResponseWriter, r *http.Request)
 	if clientData.Phone != nil {
 		business.Phone = *clientData.Phone
 	}
+	if clientData.ReminderOffsets != nil {
+		// an empty list turns reminders off, the longest offset comes first
+		business.ReminderOffsets = append([]int64{}, *clientData.ReminderOffsets...)
+		slices.Sort(business.ReminderOffsets)
+		slices.Reverse(business.ReminderOffsets)
+	}
+	if clientData.Timezone != nil {
+		business.Timezone = *clientData.Timezone
+	}
 
 	// validate the updated business data
 	v := validator.New()
@@ -255,7 +317,7 @@ func (h *Handler) DeleteBusinessHandler(w http.ResponseWriter, r *http.Request)
 	currentUser := h.contextGetUser(r)
 
 	// Check if the current user can access this Business' data
-	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, int(id))
+	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), int(id))
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 		return
@@ -285,4 +347,94 @@ func (h *Handler) DeleteBusinessHandler(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
+// SuspendBusinessHandler handles POST /v1/admin/businesses/:id/suspend
+// A suspended business disappears from the public listings and stops taking
+// bookings. Appointments already booked are left alone.
+func (h *Handler) SuspendBusinessHandler(w http.ResponseWriter, r *http.Request) {
+	business, ok := h.readBusiness(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Reason != "", "reason", "must be provided")
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 characters long")
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = h.models.Businesses.Suspend(business, input.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"business": business}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// ReinstateBusinessHandler handles POST /v1/admin/businesses/:id/reinstate
+func (h *Handler) ReinstateBusinessHandler(w http.ResponseWriter, r *http.Request) {
+	business, ok := h.readBusiness(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.models.Businesses.Reinstate(business)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"business": business}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// readBusiness loads the business in the URL. It writes the error response
+// itself and returns false if the business can't be found.
+func (h *Handler) readBusiness(w http.ResponseWriter, r *http.Request) (*data.Business, bool) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	business, err := h.models.Businesses.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return business, true
+}
@@ -10,6 +10,8 @@ import (
 type contextKey string
 
 const userContextKey = contextKey("user")
+const tokenContextKey = contextKey("token")
+const permissionsContextKey = contextKey("permissions")
 
 func (h *Handler) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	// WithValue() expects the original context along with the new
@@ -26,3 +28,29 @@ func (h *Handler) contextGetUser(r *http.Request) *data.User {
 
     return user
 }
+
+// contextSetToken stores the bearer token the request was authenticated with
+func (h *Handler) contextSetToken(r *http.Request, token string) *http.Request {
+    ctx := context.WithValue(r.Context(), tokenContextKey, token)
+    return r.WithContext(ctx)
+}
+
+// contextGetToken returns the bearer token of the request, or an empty
+// string for anonymous requests
+func (h *Handler) contextGetToken(r *http.Request) string {
+    token, _ := r.Context().Value(tokenContextKey).(string)
+    return token
+}
+
+// contextSetPermissions stores the permissions granted to the current user
+func (h *Handler) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+    ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+    return r.WithContext(ctx)
+}
+
+// contextGetPermissions returns the permissions of the current user. Anonymous
+// requests have none.
+func (h *Handler) contextGetPermissions(r *http.Request) data.Permissions {
+    permissions, _ := r.Context().Value(permissionsContextKey).(data.Permissions)
+    return permissions
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// GetAllEmailsHandler handles GET /v1/admin/emails
+// It lists the emails in the outbox, ?status=dead shows the ones that gave up.
+func (h *Handler) GetAllEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Status = utils.GetSingleQueryParameter(qs, "status", "")
+	input.Filters.Page = utils.GetSingleIntegerParameter(qs, "page", 1, v)
+	input.Filters.PageSize = utils.GetSingleIntegerParameter(qs, "page_size", 20, v)
+	input.Filters.Sort = utils.GetSingleQueryParameter(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "created_at", "next_attempt_at", "attempts", "-id", "-created_at", "-next_attempt_at", "-attempts"}
+
+	if input.Status != "" {
+		v.Check(validator.PermittedValue(input.Status, data.OutboxStatusQueued, data.OutboxStatusSending, data.OutboxStatusSent, data.OutboxStatusDead),
+			"status", "must be queued, sending, sent or dead")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	emails, metadata, err := h.models.Outbox.GetAll(input.Status, input.Filters)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"emails": emails, "metadata": metadata}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// GetEmailHandler handles GET /v1/admin/emails/:id
+func (h *Handler) GetEmailHandler(w http.ResponseWriter, r *http.Request) {
+	email, ok := h.readEmail(w, r)
+	if !ok {
+		return
+	}
+
+	err := utils.WriteJSON(w, http.StatusOK, utils.Envelope{"email": email}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// ReplayEmailHandler handles POST /v1/admin/emails/:id/replay
+// It queues a dead email again, with a fresh set of delivery attempts.
+func (h *Handler) ReplayEmailHandler(w http.ResponseWriter, r *http.Request) {
+	email, ok := h.readEmail(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.models.Outbox.Replay(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEmailNotDead):
+			h.emailNotDeadResponse(w, r, email.Status)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"email": email}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// readEmail loads the outbox email in the URL. It writes the error response
+// itself and returns false if the email can't be found.
+func (h *Handler) readEmail(w http.ResponseWriter, r *http.Request) (*data.OutboxEmail, bool) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	email, err := h.models.Outbox.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return email, true
+}
@@ -1,9 +1,15 @@
 package handlers
 
 import (
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
 )
 
 // log an error message
@@ -55,6 +61,48 @@ func (h *Handler) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	h.errorResponseJSON(w, r, http.StatusConflict, message)
 }
 
+// 409 Conflict when the appointment overlaps one that is already booked
+func (h *Handler) slotTakenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested time slot is no longer available, please choose another time"
+	h.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+// 409 Conflict when the appointment can't move to the requested status
+func (h *Handler) invalidTransitionResponse(w http.ResponseWriter, r *http.Request, from, to data.AppointmentStatus) {
+	message := fmt.Sprintf("an appointment cannot change from %s to %s", from, to)
+	h.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+// 409 Conflict when a finished appointment is edited
+func (h *Handler) appointmentFinalResponse(w http.ResponseWriter, r *http.Request, status data.AppointmentStatus) {
+	message := fmt.Sprintf("a %s appointment can no longer be changed", status)
+	h.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+// 403 Forbidden when a customer tries to delete their appointment
+func (h *Handler) cancelInsteadResponse(w http.ResponseWriter, r *http.Request) {
+	message := "appointments can't be deleted, cancel it with POST /v1/appointments/:id/cancel instead"
+	h.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+// 409 Conflict when a staff member who still has appointments is removed
+func (h *Handler) staffHasAppointmentsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the staff member has upcoming appointments, cancel them or move them to someone else first"
+	h.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+// 409 Conflict when an email that hasn't given up yet is replayed
+func (h *Handler) emailNotDeadResponse(w http.ResponseWriter, r *http.Request, status string) {
+	message := fmt.Sprintf("only dead emails can be replayed, this one is %s", status)
+	h.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
+// 409 Conflict when a user tries to set up MFA a second time
+func (h *Handler) mfaAlreadyEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is already enabled for this account"
+	h.errorResponseJSON(w, r, http.StatusConflict, message)
+}
+
 func (h *Handler) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	h.errorResponseJSON(w, r, http.StatusUnprocessableEntity, errors)
 }
@@ -65,6 +113,20 @@ func (h *Handler) rateLimitExceededResponse(w http.ResponseWriter, r *http.Reque
 	h.errorResponseJSON(w, r, http.StatusTooManyRequests, message)
 }
 
+// 429 Too Many Requests while a client has to wait after failed sign ins
+func (h *Handler) loginBackoffResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "too many failed sign in attempts, please wait before trying again"
+	h.errorResponseJSON(w, r, http.StatusTooManyRequests, message)
+}
+
+// 403 Forbidden while an account is locked after too many failed sign ins
+func (h *Handler) accountLockedResponse(w http.ResponseWriter, r *http.Request, lockedUntil time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(lockedUntil).Seconds()))))
+	message := fmt.Sprintf("this account is locked after too many failed sign in attempts, try again after %s", lockedUntil.UTC().Format(time.RFC3339))
+	h.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
 // Return a 401 status code
 func (h *Handler) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
     message := "invalid authentication credentials"
@@ -88,6 +150,19 @@ func (h *Handler) inactiveAccountResponse(w http.ResponseWriter, r *http.Request
     h.errorResponseJSON(w, r, http.StatusForbidden, message)
 }
 
+// 403 Forbidden when a request tries to change fields the user isn't
+// allowed to change
+func (h *Handler) fieldsNotPermittedResponse(w http.ResponseWriter, r *http.Request, fields []string) {
+	message := fmt.Sprintf("your user account doesn't have the necessary permissions to change: %s", strings.Join(fields, ", "))
+	h.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+// 403 Forbidden for accounts an admin suspended
+func (h *Handler) suspendedAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been suspended, please contact support"
+	h.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
 // 403 Forbidden status if bad permission
 func (h *Handler) notPermittedResponse(w http.ResponseWriter,
                                                        r *http.Request) {
@@ -1,9 +1,7 @@
 package handlers
 
 import (
-	"fmt"
 	"log/slog"
-	"sync"
 
 	"github.com/Lee26Ed/lockit_appointments/cmd/api/types"
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
@@ -15,27 +13,10 @@ type Handler struct {
 	Config types.ServerConfig
 	Logger *slog.Logger
 	models *data.Models
-	mailer *mailer.Mailer
-	wg     *sync.WaitGroup
+	mailer mailer.Mailer
 }
 
 // NewHandler function creates a new Handler instance with the provided configuration and logger.
-func NewHandler(cfg types.ServerConfig, logger *slog.Logger, models *data.Models, wg *sync.WaitGroup, mailer *mailer.Mailer) *Handler {
-	return &Handler{Config: cfg, Logger: logger, models: models, mailer: mailer, wg: wg}
+func NewHandler(cfg types.ServerConfig, logger *slog.Logger, models *data.Models, mailer mailer.Mailer) *Handler {
+	return &Handler{Config: cfg, Logger: logger, models: models, mailer: mailer}
 }
-
-
-// Accept a function and run it in the background also recover from any panic
-func (h *Handler) background(fn func()) {
-    h.wg.Add(1) // Use a wait group to ensure all goroutines finish before we exit
-    go func() {
-        defer h.wg.Done()     // signal goroutine is done
-        defer func() {
-           err := recover() 
-           if err != nil {
-                h.Logger.Error(fmt.Sprintf("%v", err))
-            }
-        }()
-       fn()     // Run the actual function
-   }()
-}
\ No newline at end of file
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetJobHandler handles GET /v1/admin/jobs/:public_id
+// It shows the status and progress of a background job.
+func (h *Handler) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	publicID := httprouter.ParamsFromContext(r.Context()).ByName("public_id")
+
+	job, err := h.models.Jobs.GetByPublicID(publicID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"job": job}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/totp"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// the issuer authenticator apps show next to the code
+const totpIssuer = "Lockit Appointments"
+
+// how long a user has to enter their code after their password
+const mfaPendingTTL = 5 * time.Minute
+
+// EnrollTOTPHandler handles POST /v1/mfa/totp/enroll
+// It creates a new secret for the current user. MFA only becomes active
+// once a code from the authenticator app is verified.
+func (h *Handler) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := h.contextGetUser(r)
+
+	if !data.CanUseMFA(h.contextGetPermissions(r)) {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_, err = h.models.MFA.Enroll(currentUser.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAAlreadyEnabled):
+			h.mfaAlreadyEnabledResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	response := utils.Envelope{
+		"secret": secret,
+		"uri":    totp.URI(totpIssuer, currentUser.Email, secret),
+	}
+
+	err = utils.WriteJSON(w, http.StatusCreated, response, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// VerifyTOTPHandler handles POST /v1/mfa/totp/verify
+// It turns MFA on once the user proves their authenticator app works and
+// returns the recovery codes. They are only shown this once.
+func (h *Handler) VerifyTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := h.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Code != "", "code", "must be provided")
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mfa, err := h.models.MFA.GetForUser(currentUser.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if mfa.Enabled() {
+		h.mfaAlreadyEnabledResponse(w, r)
+		return
+	}
+
+	err = h.models.MFA.CheckCode(mfa, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidMFACode):
+			v.AddError("code", "invalid or expired code")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	recoveryCodes, err := h.models.MFA.Enable(mfa)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	response := utils.Envelope{
+		"mfa":            mfa,
+		"recovery_codes": recoveryCodes,
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// DisableTOTPHandler handles DELETE /v1/mfa/totp
+// Turning MFA off needs a current code or a recovery code.
+func (h *Handler) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := h.contextGetUser(r)
+
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	mfa, err := h.models.MFA.GetForUser(currentUser.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = h.checkSecondFactor(mfa, input.Code, input.RecoveryCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidMFACode):
+			v.AddError("code", "invalid or expired code")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = h.models.MFA.Disable(currentUser.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "two-factor authentication has been turned off"}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// CreateMFATokenHandler handles POST /v1/tokens/mfa
+// This is the second step of signing in. It exchanges the mfa-pending token
+// from POST /v1/tokens/authenticate and a TOTP or recovery code for an
+// access and refresh token.
+func (h *Handler) CreateMFATokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTokenPlaintext(v, input.MFAToken)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := h.models.Users.GetForToken(data.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.invalidAuthenticationTokenResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	mfa, err := h.models.MFA.GetForUser(user.ID)
+	if err != nil {
+		switch {
+		// MFA was turned off since the password was checked
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.invalidAuthenticationTokenResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.IsSuspended() {
+		h.suspendedAccountResponse(w, r)
+		return
+	}
+
+	// the pending token can be tried against many codes, so guesses count
+	// towards the lockout just like wrong passwords
+	if user.IsLocked() {
+		h.accountLockedResponse(w, r, *user.LockedUntil)
+		return
+	}
+
+	err = h.checkSecondFactor(mfa, input.Code, input.RecoveryCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidMFACode):
+			err = h.recordLoginFailure(r, user, user.Username)
+			if err != nil {
+				h.serverErrorResponse(w, r, err)
+				return
+			}
+			h.invalidCredentialsResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = h.models.LoginAttempts.Reset(user.Username)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the pending token has done its job
+	err = h.models.Tokens.DeleteAllForUser(data.ScopeMFAPending, user.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, refreshToken, err := h.models.Tokens.NewSession(user.ID, accessTokenTTL, refreshTokenTTL, r.UserAgent(), utils.ClientIP(r))
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"user":          user,
+	}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkSecondFactor accepts either a TOTP code or one of the user's recovery
+// codes. Recovery codes only work once MFA is enabled.
+func (h *Handler) checkSecondFactor(mfa *data.MFA, code, recoveryCode string) error {
+	if !mfa.Enabled() {
+		return data.ErrInvalidMFACode
+	}
+	if recoveryCode != "" {
+		return h.models.MFA.UseRecoveryCode(mfa.UserID, recoveryCode)
+	}
+	return h.models.MFA.CheckCode(mfa, code)
+}
@@ -128,8 +128,23 @@ func (h *Handler) Authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		// Suspended users can't use the API, even with a valid token
+		if user.IsSuspended() {
+			h.suspendedAccountResponse(w, r)
+			return
+		}
+
+		// Look the permissions up once, every check in the request uses them
+		permissions, err := h.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+
 		// Add the retrieved user info to the context
 		r = h.contextSetUser(r, user)
+		r = h.contextSetToken(r, token)
+		r = h.contextSetPermissions(r, permissions)
 
 		// Call the next handler in the chain.
 		next.ServeHTTP(w, r)
@@ -163,19 +178,15 @@ func (h *Handler) RequireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return h.RequireAuthenticatedUser(fn)
 }
 
-func (h *Handler) RequireRole(roleName string, next http.HandlerFunc) http.HandlerFunc {
+// RequirePermission lets the request through only if the current user was
+// granted the permission, through their role or directly
+func (h *Handler) RequirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := h.contextGetUser(r)
-
-		h.Logger.Info("user", "user requireRole", user)
-
-		// Check if the user has the required role
-		if user.RoleName != roleName {
+		if !h.contextGetPermissions(r).Include(code) {
 			h.notPermittedResponse(w, r)
 			return
 		}
 
-		// User has the correct role, continue
 		next.ServeHTTP(w, r)
 	}
 
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// CreateReviewHandler handles POST /v1/appointments/:id/review
+// Only the customer of a completed appointment may review it, once.
+func (h *Handler) CreateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	currentUser := h.contextGetUser(r)
+
+	appointment, err := h.models.Appointments.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if appointment.CustomerID != currentUser.ID {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Rating  int    `json:"rating"`
+		Comment string `json:"comment"`
+	}
+
+	err = utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	review := &data.Review{
+		AppointmentID: appointment.ID,
+		BusinessID:    appointment.BusinessID,
+		ServiceName:   appointment.ServiceName,
+		CustomerID:    appointment.CustomerID,
+		CustomerName:  appointment.CustomerName,
+		Rating:        input.Rating,
+		Comment:       input.Comment,
+	}
+
+	v := validator.New()
+	v.Check(appointment.Status == data.AppointmentStatusCompleted, "appointment", "must be completed before it can be reviewed")
+	if data.ValidateReview(v, review); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	review, err = h.models.Reviews.Insert(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			v.AddError("appointment", "has already been reviewed")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"review": review}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// GetAllBusinessReviewsHandler handles GET /v1/businesses/:id/reviews
+func (h *Handler) GetAllBusinessReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	// make sure the business exists
+	business, err := h.models.Businesses.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = utils.GetSingleIntegerParameter(qs, "page", 1, v)
+	input.Filters.PageSize = utils.GetSingleIntegerParameter(qs, "page_size", 20, v)
+	input.Filters.Sort = utils.GetSingleQueryParameter(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "rating", "created_at", "-id", "-rating", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := h.models.Reviews.GetAllForBusiness(business.ID, input.Filters)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	response := utils.Envelope{
+		"reviews":        reviews,
+		"average_rating": business.AverageRating,
+		"review_count":   business.ReviewCount,
+		"metadata":       metadata,
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, response, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// ReplyToReviewHandler handles POST /v1/reviews/:id/reply
+// The business owner can post a single public reply to each review.
+func (h *Handler) ReplyToReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := h.readReview(w, r)
+	if !ok {
+		return
+	}
+
+	// get the current user
+	currentUser := h.contextGetUser(r)
+
+	// Check if the current user can access this Business' data
+	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), review.BusinessID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !canAccess {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reply string `json:"reply"`
+	}
+
+	err = utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Reply != "", "reply", "must be provided")
+	v.Check(len(input.Reply) <= 2000, "reply", "must not be more than 2000 characters long")
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = h.models.Reviews.SetReply(review, input.Reply)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReply):
+			v.AddError("reply", "has already been posted for this review")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"review": review}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// FlagReviewHandler handles POST /v1/reviews/:id/flag
+// Any user can report an abusive review, which puts it in the moderation queue.
+func (h *Handler) FlagReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := h.readReview(w, r)
+	if !ok {
+		return
+	}
+
+	// hidden reviews aren't public, so there is nothing to flag
+	if review.State == data.ReviewStateHidden {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	currentUser := h.contextGetUser(r)
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Reason != "", "reason", "must be provided")
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 characters long")
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = h.models.Reviews.Flag(review, currentUser.ID, input.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"message": "the review has been reported to the moderators"}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// GetModerationReviewsHandler handles GET /v1/admin/reviews
+func (h *Handler) GetModerationReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		State data.ReviewState
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.State = data.ReviewState(utils.GetSingleQueryParameter(qs, "state", string(data.ReviewStateFlagged)))
+	input.Filters.Page = utils.GetSingleIntegerParameter(qs, "page", 1, v)
+	input.Filters.PageSize = utils.GetSingleIntegerParameter(qs, "page_size", 20, v)
+	input.Filters.Sort = utils.GetSingleQueryParameter(qs, "sort", "-flagged_at")
+	input.Filters.SortSafelist = []string{"id", "rating", "created_at", "flagged_at", "-id", "-rating", "-created_at", "-flagged_at"}
+
+	v.Check(validator.PermittedValue(string(input.State), string(data.ReviewStateVisible), string(data.ReviewStateFlagged), string(data.ReviewStateHidden)),
+		"state", "must be visible, flagged or hidden")
+
+	if data.ValidateFilters(v, input.Filters); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := h.models.Reviews.GetAllByState(input.State, input.Filters)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// HideReviewHandler handles POST /v1/admin/reviews/:id/hide
+func (h *Handler) HideReviewHandler(w http.ResponseWriter, r *http.Request) {
+	h.moderateReview(w, r, data.ReviewStateHidden)
+}
+
+// RestoreReviewHandler handles POST /v1/admin/reviews/:id/restore
+func (h *Handler) RestoreReviewHandler(w http.ResponseWriter, r *http.Request) {
+	h.moderateReview(w, r, data.ReviewStateVisible)
+}
+
+// moderateReview records an admin's decision on a review
+func (h *Handler) moderateReview(w http.ResponseWriter, r *http.Request, state data.ReviewState) {
+	review, ok := h.readReview(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.models.Reviews.SetState(review, state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"review": review}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// readReview loads the review in the URL. It writes the error response
+// itself and returns false if the review can't be found.
+func (h *Handler) readReview(w http.ResponseWriter, r *http.Request) (*data.Review, bool) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	review, err := h.models.Reviews.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return review, true
+}
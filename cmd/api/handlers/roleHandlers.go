@@ -34,7 +34,13 @@ func (h *Handler) CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
 
 	err = h.models.Roles.Insert(role)
 	if err != nil {
-		h.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateRole):
+			v.AddError("role_name", "a role with this name already exists")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -133,11 +139,14 @@ func (h *Handler) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	v := validator.New()
+
+	// the application looks the built-in roles up by name
 	if input.RoleName != nil {
+		v.Check(!role.IsBuiltIn() || *input.RoleName == role.RoleName, "role_name", "built-in roles cannot be renamed")
 		role.RoleName = *input.RoleName
 	}
 
-	v := validator.New()
 	if data.ValidateRole(v, role); !v.IsEmpty() {
 		h.failedValidationResponse(w, r, v.Errors)
 		return
@@ -146,6 +155,9 @@ func (h *Handler) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
 	err = h.models.Roles.Update(role)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrDuplicateRole):
+			v.AddError("role_name", "a role with this name already exists")
+			h.failedValidationResponse(w, r, v.Errors)
 		case errors.Is(err, data.ErrRecordNotFound):
 			h.notFoundResponse(w, r)
 		default:
@@ -168,7 +180,25 @@ func (h *Handler) DeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.models.Roles.Delete(int(id))
+	role, err := h.models.Roles.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if role.IsBuiltIn() {
+		v := validator.New()
+		v.AddError("role_name", "built-in roles cannot be deleted")
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = h.models.Roles.Delete(role.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -184,3 +214,113 @@ func (h *Handler) DeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
 		h.serverErrorResponse(w, r, err)
 	}
 }
+
+// getRolePermissionsHandler handles GET /v1/roles/:id/permissions
+func (h *Handler) GetRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	role, err := h.models.Roles.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permissions, err := h.models.Permissions.GetAllForRole(role.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"role": role, "permissions": permissions}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// replaceRolePermissionsHandler handles PUT /v1/roles/:id/permissions
+// The permissions in the request replace everything the role had before.
+func (h *Handler) ReplaceRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	role, err := h.models.Roles.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Permissions data.Permissions `json:"permissions"`
+	}
+
+	err = utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Permissions != nil, "permissions", "must be provided")
+	// admins managing roles must not be able to lock everyone out
+	v.Check(role.RoleName != data.RoleAdmin || input.Permissions.Include("roles:manage"), "permissions", "the admin role must keep roles:manage")
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = h.models.Permissions.ReplaceForRole(role.ID, input.Permissions)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownPermission):
+			v.AddError("permissions", "contains a permission that does not exist")
+			h.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permissions, err := h.models.Permissions.GetAllForRole(role.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"role": role, "permissions": permissions}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// getAllPermissionsHandler handles GET /v1/permissions
+func (h *Handler) GetAllPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := h.models.Permissions.GetAll()
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"permissions": permissions}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
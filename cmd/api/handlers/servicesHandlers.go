@@ -158,7 +158,7 @@ func (h *Handler) UpdateServiceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, service.BusinessID)
+	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), service.BusinessID)
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 		return
@@ -242,7 +242,7 @@ func (h *Handler) DeleteServiceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, service.BusinessID)
+	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), service.BusinessID)
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// CreateStaffHandler handles POST /v1/businesses/:id/staff
+func (h *Handler) CreateStaffHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	// get the current user
+	currentUser := h.contextGetUser(r)
+
+	// Check if the current user can access this Business' data
+	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canAccess {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name       string  `json:"name"`
+		Email      string  `json:"email"`
+		Phone      string  `json:"phone"`
+		ServiceIDs []int64 `json:"service_ids"`
+	}
+
+	err = utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	staff := &data.Staff{
+		BusinessID: int(id),
+		Name:       input.Name,
+		Email:      input.Email,
+		Phone:      input.Phone,
+		Active:     true, // default to active when adding a staff member
+		ServiceIDs: input.ServiceIDs,
+	}
+
+	v := validator.New()
+	if data.ValidateStaff(v, staff); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	staff, err = h.models.Staff.Insert(staff)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrServiceNotOffered):
+			v.AddError("service_ids", "must only contain services of this business")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/businesses/%d/staff/%d", staff.BusinessID, staff.ID))
+
+	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"staff": staff}, headers)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// GetAllStaffHandler handles GET /v1/businesses/:id/staff
+func (h *Handler) GetAllStaffHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = utils.GetSingleIntegerParameter(qs, "page", 1, v)
+	input.Filters.PageSize = utils.GetSingleIntegerParameter(qs, "page_size", 20, v)
+	input.Filters.Sort = utils.GetSingleQueryParameter(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "-id", "-name"}
+
+	if data.ValidateFilters(v, input.Filters); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	staff, metadata, err := h.models.Staff.GetAllForBusiness(int(id), input.Filters)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = h.hideStaffContact(r, int(id), staff...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"staff": staff, "metadata": metadata}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// GetStaffHandler handles GET /v1/businesses/:id/staff/:staff_id
+func (h *Handler) GetStaffHandler(w http.ResponseWriter, r *http.Request) {
+	staff, ok := h.readBusinessStaff(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.hideStaffContact(r, staff.BusinessID, staff)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"staff": staff}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// UpdateStaffHandler handles PUT /v1/businesses/:id/staff/:staff_id
+func (h *Handler) UpdateStaffHandler(w http.ResponseWriter, r *http.Request) {
+	staff, ok := h.readBusinessStaff(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.canManageStaff(w, r, staff) {
+		return
+	}
+
+	var input struct {
+		Name   *string `json:"name"`
+		Email  *string `json:"email"`
+		Phone  *string `json:"phone"`
+		Active *bool   `json:"active"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		staff.Name = *input.Name
+	}
+	if input.Email != nil {
+		staff.Email = *input.Email
+	}
+	if input.Phone != nil {
+		staff.Phone = *input.Phone
+	}
+
+	v := validator.New()
+	if data.ValidateStaff(v, staff); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// staff members with upcoming appointments can't be deactivated, so
+	// that goes through its own check before anything is saved
+	if input.Active != nil && *input.Active != staff.Active {
+		if *input.Active {
+			err = h.models.Staff.Activate(staff)
+		} else {
+			err = h.models.Staff.Deactivate(staff)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				h.notFoundResponse(w, r)
+			case errors.Is(err, data.ErrStaffHasAppointments):
+				h.staffHasAppointmentsResponse(w, r)
+			default:
+				h.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	err = h.models.Staff.Update(staff)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"staff": staff}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// SetStaffServicesHandler handles PUT /v1/businesses/:id/staff/:staff_id/services
+func (h *Handler) SetStaffServicesHandler(w http.ResponseWriter, r *http.Request) {
+	staff, ok := h.readBusinessStaff(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.canManageStaff(w, r, staff) {
+		return
+	}
+
+	var input struct {
+		ServiceIDs []int64 `json:"service_ids"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.ServiceIDs != nil, "service_ids", "must be provided")
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = h.models.Staff.SetServices(staff, input.ServiceIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrServiceNotOffered):
+			v.AddError("service_ids", "must only contain services of this business")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"staff": staff}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// DeleteStaffHandler handles DELETE /v1/businesses/:id/staff/:staff_id
+// The staff member is deactivated rather than deleted so their appointments
+// stay on their calendar. Staff members with upcoming appointments can't be
+// removed until these are cancelled or moved to someone else.
+func (h *Handler) DeleteStaffHandler(w http.ResponseWriter, r *http.Request) {
+	staff, ok := h.readBusinessStaff(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.canManageStaff(w, r, staff) {
+		return
+	}
+
+	err := h.models.Staff.Deactivate(staff)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrStaffHasAppointments):
+			h.staffHasAppointmentsResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "staff member successfully removed", "staff": staff}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// readBusinessStaff loads the staff member in the URL and makes sure they
+// belong to the business in the URL. It writes the error response itself
+// and returns false if anything is wrong.
+func (h *Handler) readBusinessStaff(w http.ResponseWriter, r *http.Request) (*data.Staff, bool) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	staffID, err := utils.ReadIntParam(r, "staff_id")
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	staff, err := h.models.Staff.Get(int(staffID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if staff.BusinessID != int(id) {
+		h.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return staff, true
+}
+
+// canManageStaff checks that the current user may change the staff of the
+// business. It writes the error response itself and returns false if not.
+func (h *Handler) canManageStaff(w http.ResponseWriter, r *http.Request, staff *data.Staff) bool {
+	currentUser := h.contextGetUser(r)
+
+	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), staff.BusinessID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !canAccess {
+		h.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// hideStaffContact leaves out the email and phone number of the staff
+// members unless the current user manages their business
+func (h *Handler) hideStaffContact(r *http.Request, businessID int, staffList ...*data.Staff) error {
+	currentUser := h.contextGetUser(r)
+
+	if !currentUser.IsAnonymous() {
+		canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), businessID)
+		if err != nil {
+			return err
+		}
+		if canAccess {
+			return nil
+		}
+	}
+
+	for _, staff := range staffList {
+		staff.Email = ""
+		staff.Phone = ""
+	}
+	return nil
+}
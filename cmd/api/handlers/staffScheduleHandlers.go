@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// GetStaffScheduleHandler handles GET /v1/businesses/:id/staff/:staff_id/schedule
+func (h *Handler) GetStaffScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	staff, ok := h.readBusinessStaff(w, r)
+	if !ok {
+		return
+	}
+
+	schedule, err := h.models.StaffSchedule.GetAllForStaff(staff.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"hours": schedule}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// ReplaceStaffScheduleHandler handles PUT /v1/businesses/:id/staff/:staff_id/schedule
+// An empty list clears the schedule, after which the staff member works
+// whenever the business is open.
+func (h *Handler) ReplaceStaffScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	staff, ok := h.readBusinessStaff(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.canManageStaff(w, r, staff) {
+		return
+	}
+
+	var input struct {
+		Hours []struct {
+			DayOfWeek int    `json:"day_of_week"`
+			StartTime string `json:"start_time"`
+			EndTime   string `json:"end_time"`
+		} `json:"hours"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	schedule := []*data.StaffHours{}
+	for _, in := range input.Hours {
+		schedule = append(schedule, &data.StaffHours{
+			StaffID:   staff.ID,
+			DayOfWeek: in.DayOfWeek,
+			StartTime: in.StartTime,
+			EndTime:   in.EndTime,
+		})
+	}
+
+	businessHours, err := h.models.BusinessHours.GetAllForBusiness(staff.BusinessID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Hours != nil, "hours", "must be provided")
+	if data.ValidateStaffSchedule(v, schedule, businessHours); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = h.models.StaffSchedule.ReplaceForStaff(staff.ID, schedule)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	schedule, err = h.models.StaffSchedule.GetAllForStaff(staff.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"hours": schedule}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// CreateStaffTimeOffHandler handles POST /v1/businesses/:id/staff/:staff_id/time-off
+// Like business time off, overlapping confirmed or pending appointments of
+// the staff member are reported with a 409 unless ?cancel_conflicts=true is
+// given.
+func (h *Handler) CreateStaffTimeOffHandler(w http.ResponseWriter, r *http.Request) {
+	staff, ok := h.readBusinessStaff(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.canManageStaff(w, r, staff) {
+		return
+	}
+
+	currentUser := h.contextGetUser(r)
+
+	v := validator.New()
+	cancelConflicts := utils.GetSingleBoolParameter(r.URL.Query(), "cancel_conflicts", false, v)
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var input struct {
+		StartTime time.Time `json:"start_time"`
+		EndTime   time.Time `json:"end_time"`
+		Reason    string    `json:"reason"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	timeOff := &data.StaffTimeOff{
+		StaffID:   staff.ID,
+		StartTime: input.StartTime,
+		EndTime:   input.EndTime,
+		Reason:    input.Reason,
+	}
+
+	if data.ValidateStaffTimeOff(v, timeOff); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	overlapping, err := h.models.Appointments.GetOverlapping(staff.BusinessID, timeOff.StartTime, timeOff.EndTime,
+		data.AppointmentStatusConfirmed, data.AppointmentStatusPending)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// only the appointments of this staff member are affected
+	conflicts := []*data.Appointment{}
+	for _, appointment := range overlapping {
+		if appointment.BusinessStaffID == staff.ID {
+			conflicts = append(conflicts, appointment)
+		}
+	}
+
+	if len(conflicts) > 0 && !cancelConflicts {
+		response := utils.Envelope{
+			"error":     "the time off overlaps booked appointments, retry with cancel_conflicts=true to cancel them",
+			"conflicts": conflicts,
+		}
+		err = utils.WriteJSON(w, http.StatusConflict, response, nil)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	timeOff, err = h.models.StaffTimeOff.Insert(timeOff, currentUser.ID, conflictCancellations(conflicts, timeOff.Reason)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			h.editConflictResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/businesses/%d/staff/%d/time-off/%d", staff.BusinessID, staff.ID, timeOff.ID))
+
+	response := utils.Envelope{
+		"time_off":               timeOff,
+		"cancelled_appointments": conflicts,
+	}
+	err = utils.WriteJSON(w, http.StatusCreated, response, headers)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// GetAllStaffTimeOffHandler handles GET /v1/businesses/:id/staff/:staff_id/time-off
+func (h *Handler) GetAllStaffTimeOffHandler(w http.ResponseWriter, r *http.Request) {
+	staff, ok := h.readBusinessStaff(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.canManageStaff(w, r, staff) {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = utils.GetSingleIntegerParameter(qs, "page", 1, v)
+	input.Filters.PageSize = utils.GetSingleIntegerParameter(qs, "page_size", 20, v)
+	input.Filters.Sort = utils.GetSingleQueryParameter(qs, "sort", "start_datetime")
+	input.Filters.SortSafelist = []string{"id", "start_datetime", "-id", "-start_datetime"}
+
+	if data.ValidateFilters(v, input.Filters); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	timeOff, metadata, err := h.models.StaffTimeOff.GetAllForStaff(staff.ID, input.Filters)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"time_off": timeOff, "metadata": metadata}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// DeleteStaffTimeOffHandler handles DELETE /v1/businesses/:id/staff/:staff_id/time-off/:time_off_id
+func (h *Handler) DeleteStaffTimeOffHandler(w http.ResponseWriter, r *http.Request) {
+	staff, ok := h.readBusinessStaff(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.canManageStaff(w, r, staff) {
+		return
+	}
+
+	timeOffID, err := utils.ReadIntParam(r, "time_off_id")
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	timeOff, err := h.models.StaffTimeOff.Get(int(timeOffID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the time off has to belong to the staff member in the URL
+	if timeOff.StaffID != staff.ID {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	err = h.models.StaffTimeOff.Delete(timeOff.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "time off successfully deleted"}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
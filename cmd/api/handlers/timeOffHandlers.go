@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// CreateTimeOffHandler handles POST /v1/businesses/:id/time-off
+// If the time off overlaps confirmed or pending appointments a 409 listing
+// them is returned, unless ?cancel_conflicts=true is given in which case they
+// are cancelled along with saving the time off and both sides are emailed.
+func (h *Handler) CreateTimeOffHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	// get the current user
+	currentUser := h.contextGetUser(r)
+
+	// Check if the current user can access this Business' data
+	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canAccess {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	cancelConflicts := utils.GetSingleBoolParameter(r.URL.Query(), "cancel_conflicts", false, v)
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var input struct {
+		StartTime time.Time `json:"start_time"`
+		EndTime   time.Time `json:"end_time"`
+		Reason    string    `json:"reason"`
+	}
+
+	err = utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	timeOff := &data.TimeOff{
+		BusinessID: int(id),
+		StartTime:  input.StartTime,
+		EndTime:    input.EndTime,
+		Reason:     input.Reason,
+	}
+
+	if data.ValidateTimeOff(v, timeOff); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	conflicts, err := h.models.Appointments.GetOverlapping(timeOff.BusinessID, timeOff.StartTime, timeOff.EndTime,
+		data.AppointmentStatusConfirmed, data.AppointmentStatusPending)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(conflicts) > 0 && !cancelConflicts {
+		response := utils.Envelope{
+			"error":     "the time off overlaps booked appointments, retry with cancel_conflicts=true to cancel them",
+			"conflicts": conflicts,
+		}
+		err = utils.WriteJSON(w, http.StatusConflict, response, nil)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	timeOff, err = h.models.TimeOff.Insert(timeOff, currentUser.ID, conflictCancellations(conflicts, timeOff.Reason)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			h.editConflictResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/businesses/%d/time-off/%d", timeOff.BusinessID, timeOff.ID))
+
+	response := utils.Envelope{
+		"time_off":               timeOff,
+		"cancelled_appointments": conflicts,
+	}
+	err = utils.WriteJSON(w, http.StatusCreated, response, headers)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// GetAllTimeOffHandler handles GET /v1/businesses/:id/time-off
+func (h *Handler) GetAllTimeOffHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ID from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	// get the current user
+	currentUser := h.contextGetUser(r)
+
+	// Check if the current user can access this Business' data
+	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canAccess {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = utils.GetSingleIntegerParameter(qs, "page", 1, v)
+	input.Filters.PageSize = utils.GetSingleIntegerParameter(qs, "page_size", 20, v)
+	input.Filters.Sort = utils.GetSingleQueryParameter(qs, "sort", "start_datetime")
+	input.Filters.SortSafelist = []string{"id", "start_datetime", "-id", "-start_datetime"}
+
+	if data.ValidateFilters(v, input.Filters); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	timeOff, metadata, err := h.models.TimeOff.GetAllForBusiness(int(id), input.Filters)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"time_off": timeOff, "metadata": metadata}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// DeleteTimeOffHandler handles DELETE /v1/businesses/:id/time-off/:time_off_id
+func (h *Handler) DeleteTimeOffHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the IDs from the URL
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	timeOffID, err := utils.ReadIntParam(r, "time_off_id")
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	// get the current user
+	currentUser := h.contextGetUser(r)
+
+	// Check if the current user can access this Business' data
+	canAccess, err := h.models.Businesses.CanAccessBusinessData(currentUser, h.contextGetPermissions(r), int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !canAccess {
+		h.notPermittedResponse(w, r)
+		return
+	}
+
+	timeOff, err := h.models.TimeOff.Get(int(timeOffID))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the time off has to belong to the business in the URL
+	if timeOff.BusinessID != int(id) {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	err = h.models.TimeOff.Delete(timeOff.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "time off successfully deleted"}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// conflictCancellations cancels appointments that clash with newly added
+// time off. Both sides of each appointment are emailed once the time off is
+// saved.
+func conflictCancellations(conflicts []*data.Appointment, reason string) []*data.Cancellation {
+	cancellations := []*data.Cancellation{}
+	for _, appointment := range conflicts {
+		next := *appointment
+		next.Status = data.AppointmentStatusCancelled
+		next.Sequence++
+		cancellations = append(cancellations, &data.Cancellation{
+			Appointment: appointment,
+			Emails: appointmentEmails(&next, "appointment_cancelled.tmpl", map[string]any{
+				"byBusiness": true,
+				"reason":     reason,
+			}),
+		})
+	}
+
+	return cancellations
+}
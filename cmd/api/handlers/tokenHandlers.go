@@ -9,6 +9,14 @@ import (
 	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Access tokens are short lived, clients use the refresh token to get a new
+// one without asking for the password again
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
 )
 
 // createAuthTokenHandler handles POST /v1/tokens/authentication
@@ -33,8 +41,18 @@ func (h *Handler) CreateAuthTokenHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Default TTL to 24 hours if not provided
-	ttl := 24 * time.Hour
+	// Has this username or address failed recently? Each failure doubles
+	// the wait before the next try.
+	retryAfter, err := h.models.LoginAttempts.RetryAfter(input.Username, utils.ClientIP(r), h.Config.Lockout.BaseDelay, h.Config.Lockout.MaxDelay)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if retryAfter > 0 {
+		h.loginBackoffResponse(w, r, retryAfter)
+		return
+	}
 
 	// Is there an associated user for the provided username?
     user, err := h.models.Users.GetByUsername(input.Username)
@@ -42,6 +60,12 @@ func (h *Handler) CreateAuthTokenHandler(w http.ResponseWriter, r *http.Request)
     if err != nil {
         switch {
             case errors.Is(err, data.ErrRecordNotFound):
+                // count it anyway so unknown usernames look the same
+                err = h.recordLoginFailure(r, nil, input.Username)
+                if err != nil {
+                    h.serverErrorResponse(w, r, err)
+                    return
+                }
                 h.invalidCredentialsResponse(w, r)
             default:
                 h.serverErrorResponse(w, r, err)
@@ -49,6 +73,19 @@ func (h *Handler) CreateAuthTokenHandler(w http.ResponseWriter, r *http.Request)
         return
     }
 
+	// Locked accounts can't sign in, even with the right password. They get
+	// the same response as unknown usernames so the lock doesn't give away
+	// that the account exists, the owner learns about it from the email.
+	if user.IsLocked() {
+		err = h.recordLoginFailure(r, nil, input.Username)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+		h.invalidCredentialsResponse(w, r)
+		return
+	}
+
 	// The user is found. Does their password match?
 	match, err := user.Password.Matches(input.Password)
     if err != nil {
@@ -58,27 +95,145 @@ func (h *Handler) CreateAuthTokenHandler(w http.ResponseWriter, r *http.Request)
 
 	// Wrong password
 	if !match {
+		err = h.recordLoginFailure(r, user, input.Username)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
 		h.invalidCredentialsResponse(w, r)
 		return
 	}
 
+	err = h.models.LoginAttempts.Reset(user.Username)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.IsSuspended() {
+		h.suspendedAccountResponse(w, r)
+		return
+	}
+
 	// Is the user activated?
 	if !user.IsActivated {
 		h.inactiveAccountResponse(w, r)
 		return
 	}
 
-	// Create the token
-	token, err := h.models.Tokens.New(user.ID, ttl, data.ScopeAuthentication)
+	// Accounts with two-factor authentication get a short-lived token that
+	// is exchanged for real tokens at POST /v1/tokens/mfa
+	mfa, err := h.models.MFA.GetForUser(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if mfa != nil && mfa.Enabled() {
+		mfaToken, err := h.models.Tokens.New(user.ID, mfaPendingTTL, data.ScopeMFAPending)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		}, nil)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Create the tokens, remembering which client they were issued to
+	token, refreshToken, err := h.models.Tokens.NewSession(user.ID, accessTokenTTL, refreshTokenTTL, r.UserAgent(), utils.ClientIP(r))
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Return both tokens and user data for the client
+	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"user":          user,
+	}, nil)
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// recordLoginFailure counts a failed sign in. Once a user reaches the
+// configured number of failures their account is locked for a while and they
+// get an email about it.
+func (h *Handler) recordLoginFailure(r *http.Request, user *data.User, username string) error {
+	failures, err := h.models.LoginAttempts.RecordFailure(username, utils.ClientIP(r), h.Config.Lockout.Duration)
+	if err != nil {
+		return err
+	}
+
+	if user == nil || failures < h.Config.Lockout.MaxAttempts {
+		return nil
+	}
+
+	lockedUntil := time.Now().Add(h.Config.Lockout.Duration)
+	email := &data.QueuedEmail{
+		Recipient: user.Email,
+		Template:  "account_locked.tmpl",
+		Data: map[string]any{
+			"username":    user.Username,
+			"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+			"ipAddress":   utils.ClientIP(r),
+		},
+	}
+
+	err = h.models.Users.SetLockedUntil(user.ID, &lockedUntil, email)
+	if err != nil {
+		return err
+	}
+
+	// the lock takes over, counting starts again once it runs out
+	return h.models.LoginAttempts.Reset(user.Username)
+}
+
+// refreshAuthTokenHandler handles POST /v1/tokens/refresh
+// It exchanges a refresh token for a new access token and a new refresh
+// token. Refresh tokens only work once.
+func (h *Handler) RefreshAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, refreshToken, err := h.models.Tokens.Rotate(input.RefreshToken, accessTokenTTL, refreshTokenTTL, r.UserAgent(), utils.ClientIP(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			h.Logger.Warn("refresh token reused, session revoked", "remote_addr", r.RemoteAddr)
+			h.invalidAuthenticationTokenResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	// Return both token and user data for the client
 	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{
-		"token": token,
-		"user":  user,
+		"token":         token,
+		"refresh_token": refreshToken,
 	}, nil)
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
@@ -86,9 +241,12 @@ func (h *Handler) CreateAuthTokenHandler(w http.ResponseWriter, r *http.Request)
 }
 
 // createActivationTokenHandler handles POST /v1/tokens/activation
+// It emails a new activation token to an account that isn't activated yet.
+// The response is the same whether or not the email belongs to such an
+// account so the endpoint can't be used to find out who is registered.
 func (h *Handler) CreateActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		UserID int `json:"user_id"`
+		Email string `json:"email"`
 	}
 
 	err := utils.ReadJSON(w, r, &input)
@@ -98,21 +256,89 @@ func (h *Handler) CreateActivationTokenHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	v := validator.New()
-	v.Check(input.UserID > 0, "user_id", "must be provided")
-
-	if !v.IsEmpty() {
+	if data.ValidateEmail(v, input.Email); !v.IsEmpty() {
 		h.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Activation tokens typically have shorter TTL (e.g., 3 days)
-	token, err := h.models.Tokens.New(input.UserID, 3*24*time.Hour, data.ScopeActivation)
+	response := utils.Envelope{"message": "if an account that still needs activating uses this email address you will receive activation instructions"}
+
+	user, err := h.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// only accounts waiting for activation get a new token, the old ones
+	// stop working
+	if user != nil && !user.IsActivated && !user.IsSuspended() {
+		err = h.sendActivationEmail(user)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = utils.WriteJSON(w, http.StatusAccepted, response, nil)
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler handles POST /v1/tokens/password-reset
+// The response is the same whether or not the email belongs to an account
+// so the endpoint can't be used to find out who is registered.
+func (h *Handler) CreatePasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
 		return
 	}
 
-	err = utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"token": token}, nil)
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	response := utils.Envelope{"message": "if an activated account uses this email address you will receive password reset instructions"}
+
+	user, err := h.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// only activated accounts can reset their password
+	if user != nil && user.IsActivated {
+		// Password reset tokens are short lived
+		token, err := data.GenerateToken(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+
+		email := &data.QueuedEmail{
+			Recipient: user.Email,
+			Template:  "password_reset.tmpl",
+			Data: map[string]any{
+				"passwordResetToken": token.Plaintext,
+				"username":           user.Username,
+			},
+		}
+
+		err = h.models.Tokens.Insert(token, email)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = utils.WriteJSON(w, http.StatusAccepted, response, nil)
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 	}
@@ -120,9 +346,22 @@ func (h *Handler) CreateActivationTokenHandler(w http.ResponseWriter, r *http.Re
 
 // deleteAllTokensForUserHandler handles DELETE /v1/tokens/user/:user_id
 func (h *Handler) DeleteAllTokensForUserHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := utils.ReadIDParam(r)
+	userID, err := utils.ReadIntParam(r, "user_id")
 	if err != nil {
-		h.badRequestResponse(w, r, err)
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	// users can only sign themselves out, unless they manage users
+	currentUser := h.contextGetUser(r)
+	canAccess, err := h.models.Users.CanAccessUserData(currentUser, h.contextGetPermissions(r), int(userID))
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !canAccess {
+		h.notPermittedResponse(w, r)
 		return
 	}
 
@@ -140,7 +379,12 @@ func (h *Handler) DeleteAllTokensForUserHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = h.models.Tokens.DeleteAllForUser(scope, int(userID))
+	// signing out of every session revokes the refresh tokens as well
+	if scope == data.ScopeAuthentication {
+		err = h.models.Tokens.DeleteAllSessionsForUser(int(userID))
+	} else {
+		err = h.models.Tokens.DeleteAllForUser(scope, int(userID))
+	}
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 		return
@@ -151,3 +395,100 @@ func (h *Handler) DeleteAllTokensForUserHandler(w http.ResponseWriter, r *http.R
 		h.serverErrorResponse(w, r, err)
 	}
 }
+
+// deleteCurrentTokenHandler handles DELETE /v1/tokens/current
+// It signs out the session of the token used for the request, including its
+// refresh token, and leaves other sessions alone.
+func (h *Handler) DeleteCurrentTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := h.models.Tokens.DeleteSessionByAccessToken(h.contextGetToken(r))
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "you have been signed out"}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// getUserSessionsHandler handles GET /v1/users/:id/sessions
+// "me" can be used as the id to list the sessions of the current user.
+func (h *Handler) GetUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.readSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.models.Tokens.GetSessionsForUser(userID, h.contextGetToken(r))
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"sessions": sessions}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteUserSessionHandler handles DELETE /v1/users/:id/sessions/:token_id
+func (h *Handler) DeleteUserSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.readSessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	tokenID, err := utils.ReadIntParam(r, "token_id")
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return
+	}
+
+	err = h.models.Tokens.DeleteSessionForUser(int(tokenID), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// readSessionUser works out whose sessions are being managed. The id in the
+// URL can be "me" for the current user, other users' sessions are only
+// available with users:manage. It writes the error response itself and returns
+// false if the request can't go ahead.
+func (h *Handler) readSessionUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	currentUser := h.contextGetUser(r)
+
+	if httprouter.ParamsFromContext(r.Context()).ByName("id") == "me" {
+		return currentUser.ID, true
+	}
+
+	userID, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return 0, false
+	}
+
+	canAccess, err := h.models.Users.CanAccessUserData(currentUser, h.contextGetPermissions(r), int(userID))
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return 0, false
+	}
+
+	if !canAccess {
+		h.notPermittedResponse(w, r)
+		return 0, false
+	}
+
+	return int(userID), true
+}
@@ -10,6 +10,9 @@ import (
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
 )
 
+// how long the link to confirm a new email address works
+const emailChangeTTL = 24 * time.Hour
+
 func (h *Handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var clientData struct {
 		Email   string `json:"email"`
@@ -30,10 +33,16 @@ func (h *Handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set role_id to 1 for the first user (admin), otherwise 3 (regular user)
-	roleID := 3
+	// The first user to register becomes the admin, everyone else is a regular user
+	roleName := data.RoleUser
 	if userCount == 0 {
-		roleID = 1
+		roleName = data.RoleAdmin
+	}
+
+	role, err := h.models.Roles.GetByName(roleName)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
 	}
 
 	// copy the data from the clientData struct to a new db User struct
@@ -42,7 +51,7 @@ func (h *Handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 		Username: clientData.Username,
 		Status: data.UserStatusPending, // default status for new users
 		IsActivated: false, // new users are not activated by default
-		RoleID: roleID, // set role based on user count
+		RoleID: role.ID, // set role based on user count
 	}
 
 	// hash the password and store it in the User struct
@@ -75,8 +84,7 @@ func (h *Handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate a new activation token which expires in 3 days
-	token, err := h.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	err = h.sendActivationEmail(user)
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 		return
@@ -84,25 +92,112 @@ func (h *Handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	response := utils.Envelope{"user": user}
 
-	h.background(func() {
-		data := map[string]any{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-			"username":        user.Username,
-		}
+	err = utils.WriteJSON(w, http.StatusCreated, response, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
 
-		err = h.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			h.Logger.Error(err.Error())
+// sendEmailChangeConfirmation emails a token to the new address, which proves
+// the user owns it, and lets the old address know about the change. Earlier
+// email change requests of the user stop working.
+func (h *Handler) sendEmailChangeConfirmation(user *data.User, newEmail string) error {
+	token, err := data.GenerateToken(user.ID, emailChangeTTL, data.ScopeEmailChange)
+	if err != nil {
+		return err
+	}
+	token.PendingEmail = newEmail
+
+	confirmation := &data.QueuedEmail{
+		Recipient: newEmail,
+		Template:  "email_change.tmpl",
+		Data: map[string]any{
+			"emailChangeToken": token.Plaintext,
+			"username":         user.Username,
+			"newEmail":         newEmail,
+		},
+	}
+
+	// the old address must not get the token, whoever reads it could
+	// confirm the change
+	notice := &data.QueuedEmail{
+		Recipient: user.Email,
+		Template:  "email_change_notice.tmpl",
+		Data: map[string]any{
+			"username": user.Username,
+			"newEmail": newEmail,
+		},
+	}
+
+	return h.models.Tokens.Replace(token, confirmation, notice)
+}
+
+// ConfirmEmailChangeHandler handles PUT /v1/users/email/confirm
+// It switches the user's email to the address the token was sent to.
+func (h *Handler) ConfirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID, err := h.models.Users.ConfirmEmailChange(input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			h.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "email address already in use")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
 		}
-	})
+		return
+	}
 
-	err = utils.WriteJSON(w, http.StatusCreated, response, nil)
+	user, err := h.models.Users.Get(userID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user}, nil)
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 	}
 }
 
+// sendActivationEmail replaces any activation token of the user with a new
+// one, which expires in 3 days, and queues an email with it
+func (h *Handler) sendActivationEmail(user *data.User) error {
+	token, err := data.GenerateToken(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	email := &data.QueuedEmail{
+		Recipient: user.Email,
+		Template:  "user_welcome.tmpl",
+		Data: map[string]any{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+			"username":        user.Username,
+		},
+	}
+
+	return h.models.Tokens.Replace(token, email)
+}
 
 func (h *Handler) ActivateUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the body from the request and store in temporary struct
@@ -137,6 +232,12 @@ func (h *Handler) ActivateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// activating sets the status, which would end a suspension
+	if user.IsSuspended() {
+		h.suspendedAccountResponse(w, r)
+		return
+	}
+
 	// User provided the right token so activate them
 	h.Logger.Info("Activating user", "user_id", user.ID, "username", user.Username, "email", user.Email)
 	err = h.models.Users.UpdateActivation(user.ID, data.UserStatusActive, true)
@@ -174,6 +275,79 @@ func (h *Handler) ActivateUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// UpdateUserPasswordHandler handles PUT /v1/users/password
+// It sets a new password using a password reset token and signs the user out
+// everywhere.
+func (h *Handler) UpdateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := h.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.SetPassword(input.Password)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = h.models.Users.UpdatePassword(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "user not found")
+			h.failedValidationResponse(w, r, v.Errors)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the token can't be used again, and anyone still signed in with the
+	// old password is signed out
+	err = h.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = h.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
 
 func (h *Handler) GetUserHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the ID from the URL
@@ -186,14 +360,15 @@ func (h *Handler) GetUserHandler(w http.ResponseWriter, r *http.Request) {
 	// get the current user 
 	currentUser := h.contextGetUser(r)
 
-	// Check if the current user can access this user's data
-	canAccess, err := h.models.Users.CanAccessUserData(currentUser, int(id))
+	// Check if the current user can access this user's data. users:read is
+	// enough to look at someone else.
+	canAccess, err := h.models.Users.CanAccessUserData(currentUser, h.contextGetPermissions(r), int(id))
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 		return
 	}
 
-	if !canAccess {
+	if !canAccess && !h.contextGetPermissions(r).Include("users:read") {
 		h.notPermittedResponse(w, r)
 		return
 	}
@@ -268,7 +443,7 @@ func (h *Handler) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 	currentUser := h.contextGetUser(r)
 
 	// Check if the current user can access this user's data
-	canAccess, err := h.models.Users.CanAccessUserData(currentUser, int(id))
+	canAccess, err := h.models.Users.CanAccessUserData(currentUser, h.contextGetPermissions(r), int(id))
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 		return
@@ -293,8 +468,11 @@ func (h *Handler) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Parse the request body for updates
 	var clientData struct {
-		Password    *string `json:"password,omitempty"`
-		Email       *string `json:"email"`
+		Username    *string       `json:"username"`
+		Password    *string       `json:"password,omitempty"`
+		Email       *string       `json:"email"`
+		RoleID      *int          `json:"role_id"`
+		Status      *data.Status  `json:"status"`
 	}
 
 
@@ -304,11 +482,31 @@ func (h *Handler) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Users manage their own profile, only users:manage can change role or status
+	var deniedFields []string
+	if !h.contextGetPermissions(r).Include("users:manage") {
+		if clientData.RoleID != nil {
+			deniedFields = append(deniedFields, "role_id")
+		}
+		if clientData.Status != nil {
+			deniedFields = append(deniedFields, "status")
+		}
+	}
+
+	if len(deniedFields) > 0 {
+		h.fieldsNotPermittedResponse(w, r, deniedFields)
+		return
+	}
+
 		// Update only the fields that were provided
-	if clientData.Email != nil {
-		user.Email = *clientData.Email
+	if clientData.Username != nil {
+		user.Username = *clientData.Username
 	}
 
+	// A new email address only replaces the old one once the user confirms
+	// it through PUT /v1/users/email/confirm
+	emailChanged := clientData.Email != nil && *clientData.Email != user.Email
+
 	// Update password if provided
 	if clientData.Password != nil {
 		err = user.Password.SetPassword(*clientData.Password)
@@ -320,7 +518,37 @@ func (h *Handler) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Validate the updated user data
 	v := validator.New()
-	if data.ValidateUser(v, user); !v.IsEmpty() {
+	data.ValidateUser(v, user)
+
+	if emailChanged {
+		data.ValidateEmail(v, *clientData.Email)
+
+		existing, err := h.models.Users.GetByEmail(*clientData.Email)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(existing == nil, "email", "email address already in use")
+	}
+
+	if clientData.Status != nil {
+		// suspensions need a reason, they go through POST /v1/admin/users/:id/suspend
+		v.Check(validator.PermittedValue(string(*clientData.Status), string(data.UserStatusActive), string(data.UserStatusPending)),
+			"status", "must be active or pending")
+		v.Check(!user.IsSuspended(), "status", "suspended users are reinstated through POST /v1/admin/users/:id/reinstate")
+	}
+
+	var role *data.Role
+	if clientData.RoleID != nil {
+		role, err = h.models.Roles.Get(*clientData.RoleID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(role != nil, "role_id", "role does not exist")
+	}
+
+	if !v.IsEmpty() {
 		h.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -332,15 +560,51 @@ func (h *Handler) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
 		case errors.Is(err, data.ErrDuplicateEmail):
 			v.AddError("email", "email address already in use")
 			h.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateUsername):
+			v.AddError("username", "username already in use")
+			h.failedValidationResponse(w, r, v.Errors)
 		default:
 			h.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
+	if role != nil {
+		err = h.models.Users.UpdateRole(user, role.ID)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+		user.RoleName = role.RoleName
+	}
+
+	if clientData.Status != nil {
+		user.Status = *clientData.Status
+		user.IsActivated = user.Status == data.UserStatusActive
+	}
+
+	if clientData.Status != nil {
+		err = h.models.Users.UpdateActivation(user.ID, user.Status, user.IsActivated)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if emailChanged {
+		err = h.sendEmailChangeConfirmation(user, *clientData.Email)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
 	response := utils.Envelope{
 		"user": user,
 	}
+	if emailChanged {
+		response["message"] = "a confirmation link was sent to your new email address, your email will change once you confirm it"
+	}
 	err = utils.WriteJSON(w, http.StatusOK, response, nil)
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
@@ -361,7 +625,7 @@ func (h *Handler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	currentUser := h.contextGetUser(r)
 
 	// Check if the current user can access this user's data
-	canAccess, err := h.models.Users.CanAccessUserData(currentUser, int(id))
+	canAccess, err := h.models.Users.CanAccessUserData(currentUser, h.contextGetPermissions(r), int(id))
 	if err != nil {
 		h.serverErrorResponse(w, r, err)
 		return
@@ -392,3 +656,143 @@ func (h *Handler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		h.serverErrorResponse(w, r, err)
 	}
 }
+
+// UnlockUserHandler handles POST /v1/admin/users/:id/unlock
+// It lifts a lockout early and forgets the user's failed sign ins.
+func (h *Handler) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.models.Users.SetLockedUntil(user.ID, nil)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = h.models.LoginAttempts.Reset(user.Username)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.LockedUntil = nil
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// SuspendUserHandler handles POST /v1/admin/users/:id/suspend
+// A suspended user is signed out everywhere and can't sign back in until
+// an admin reinstates them.
+func (h *Handler) SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := utils.ReadJSON(w, r, &input)
+	if err != nil {
+		h.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Reason != "", "reason", "must be provided")
+	v.Check(len(input.Reason) <= 500, "reason", "must not be more than 500 characters long")
+	v.Check(user.ID != h.contextGetUser(r).ID, "id", "you cannot suspend your own account")
+	if !v.IsEmpty() {
+		h.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = h.models.Users.Suspend(user, input.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = h.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// tokens emailed before the suspension must not work during it
+	for _, scope := range []string{data.ScopeActivation, data.ScopePasswordReset, data.ScopeEmailChange} {
+		err = h.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			h.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// ReinstateUserHandler handles POST /v1/admin/users/:id/reinstate
+func (h *Handler) ReinstateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.models.Users.Reinstate(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user}, nil)
+	if err != nil {
+		h.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUser loads the user in the URL. It writes the error response itself
+// and returns false if the user can't be found.
+func (h *Handler) readUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		h.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := h.models.Users.Get(int(id))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			h.notFoundResponse(w, r)
+		default:
+			h.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
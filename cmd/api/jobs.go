@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/jobs"
+)
+
+// The kinds of background jobs
+const (
+	jobCleanup = "cleanup"
+)
+
+// cleanupPayload says how long finished records are kept
+type cleanupPayload struct {
+	RetentionDays int `json:"retention_days"`
+}
+
+// registerJobs tells the job runner how to run each kind of job
+func (app *applicationDependencies) registerJobs() {
+	jobs.Register(app.jobs, jobCleanup, app.cleanupJob)
+}
+
+// cleanupJob deletes records nobody needs any more: expired tokens, old
+// failed sign ins, delivered emails and finished jobs
+func (app *applicationDependencies) cleanupJob(ctx context.Context, job *jobs.Job, payload cleanupPayload) error {
+	retention := time.Duration(payload.RetentionDays) * 24 * time.Hour
+
+	steps := []struct {
+		name string
+		run  func() (int64, error)
+	}{
+		{"tokens", app.models.Tokens.DeleteExpired},
+		{"login attempts", func() (int64, error) { return app.models.LoginAttempts.DeleteStale(retention) }},
+		{"emails", func() (int64, error) { return app.models.Outbox.DeleteSent(retention) }},
+		{"jobs", func() (int64, error) { return app.models.Jobs.DeleteFinished(retention) }},
+	}
+
+	for i, step := range steps {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		deleted, err := step.run()
+		if err != nil {
+			return err
+		}
+		app.logger.Info("cleaned up", "records", step.name, "deleted", deleted)
+
+		err = job.Progress((i + 1) * 100 / len(steps))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
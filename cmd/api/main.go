@@ -11,12 +11,15 @@ import (
 	"os"
 	"runtime"
 	"strings"
-	"sync"
 	"time"
+	_ "time/tzdata" // business time zones work without zoneinfo on the host
 
 	"github.com/Lee26Ed/lockit_appointments/cmd/api/types"
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/jobs"
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/mailer"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/mailqueue"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/reminders"
 	_ "github.com/lib/pq"
 )
 
@@ -33,7 +36,9 @@ type applicationDependencies struct {
     logger *slog.Logger
 	models *data.Models
 	mailer mailer.Mailer
-	wg sync.WaitGroup
+	mailQueue *mailqueue.Worker
+	reminders *reminders.Scheduler
+	jobs *jobs.Runner
 }
 
 func loadConfig() types.ServerConfig {
@@ -60,6 +65,52 @@ func loadConfig() types.ServerConfig {
 			return nil
 		})
 
+	// Lockout settings
+	flag.IntVar(&settings.Lockout.MaxAttempts, "lockout-max-attempts", 5,
+		"Failed sign ins before an account is locked")
+	flag.DurationVar(&settings.Lockout.Duration, "lockout-duration", 15*time.Minute,
+		"How long a locked account stays locked")
+	flag.DurationVar(&settings.Lockout.BaseDelay, "lockout-base-delay", time.Second,
+		"Wait after the first failed sign in, doubled after each further failure")
+	flag.DurationVar(&settings.Lockout.MaxDelay, "lockout-max-delay", 5*time.Minute,
+		"Longest wait between failed sign ins")
+
+	// Mail settings
+	flag.StringVar(&settings.Mail.Driver, "mail-driver", mailer.DriverSMTP,
+		"How emails are delivered (smtp|file|memory)")
+	flag.StringVar(&settings.Mail.Dir, "mail-dir", "./tmp/mail",
+		"Directory the file mail driver writes .eml files to")
+	flag.IntVar(&settings.Mail.Workers, "mail-workers", 2,
+		"Number of workers delivering queued emails")
+	flag.DurationVar(&settings.Mail.PollInterval, "mail-poll-interval", 5*time.Second,
+		"How often idle mail workers look for queued emails")
+	flag.IntVar(&settings.Mail.MaxAttempts, "mail-max-attempts", 8,
+		"Delivery attempts before an email is dead")
+	flag.DurationVar(&settings.Mail.RetryBase, "mail-retry-base", 30*time.Second,
+		"Wait after the first failed delivery, doubled after each further failure")
+	flag.DurationVar(&settings.Mail.RetryMax, "mail-retry-max", time.Hour,
+		"Longest wait between delivery attempts")
+
+	// Job settings
+	flag.IntVar(&settings.Jobs.Workers, "jobs-workers", 2,
+		"Number of workers running background jobs")
+	flag.DurationVar(&settings.Jobs.PollInterval, "jobs-poll-interval", 5*time.Second,
+		"How often idle job workers look for jobs")
+	flag.DurationVar(&settings.Jobs.RetryBase, "jobs-retry-base", 30*time.Second,
+		"Wait after the first failed run of a job, doubled after each further failure")
+	flag.DurationVar(&settings.Jobs.RetryMax, "jobs-retry-max", time.Hour,
+		"Longest wait between runs of a failing job")
+	flag.DurationVar(&settings.Jobs.CleanupInterval, "jobs-cleanup-interval", 24*time.Hour,
+		"How often expired tokens, delivered emails and finished jobs are deleted")
+	flag.IntVar(&settings.Jobs.RetentionDays, "jobs-retention-days", 30,
+		"Days delivered emails, finished jobs and failed sign ins are kept")
+
+	// Reminder settings
+	flag.BoolVar(&settings.Reminders.Enabled, "reminders-enabled", true,
+		"Email customers ahead of their confirmed appointments")
+	flag.DurationVar(&settings.Reminders.Interval, "reminders-interval", time.Minute,
+		"How often to look for appointments that are due for a reminder")
+
 	// SMTP settings
 	flag.StringVar(&settings.SMTP.Host, "smtp-host", smtpHost, "SMTP host")
 	flag.IntVar(&settings.SMTP.Port, "smtp-port", smtpPort, "SMTP port")
@@ -97,13 +148,39 @@ func main() {
 	}
 	fmt.Println("Successfully connected with context timeout")
 
+	models := data.CreateModels(db)
+
+	// pick the mail driver. Handlers only queue emails in the outbox, the
+	// mail queue workers hand them to the driver
+	transport, err := mailer.New(settings.Mail.Driver, settings.Mail.Dir, settings.SMTP.Host, settings.SMTP.Port, settings.SMTP.Username, settings.SMTP.Password, settings.SMTP.Sender)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger.Info("mailer ready", "driver", settings.Mail.Driver)
+
+	mailQueue := mailqueue.New(models.Outbox, transport, settings.Mail.Driver, logger, mailqueue.Config{
+		Workers:      settings.Mail.Workers,
+		PollInterval: settings.Mail.PollInterval,
+		MaxAttempts:  settings.Mail.MaxAttempts,
+		BaseDelay:    settings.Mail.RetryBase,
+		MaxDelay:     settings.Mail.RetryMax,
+	})
+
 	app := &applicationDependencies {
         config: settings,
         logger: logger,
-        models: data.CreateModels(db),
-		wg: sync.WaitGroup{},
-		mailer: mailer.New(settings.SMTP.Host, settings.SMTP.Port, settings.SMTP.Username, settings.SMTP.Password, settings.SMTP.Sender),
+        models: models,
+		mailer: mailer.NewQueue(models.Outbox),
+		mailQueue: mailQueue,
+		reminders: reminders.New(models.Reminders, logger, settings.Reminders.Interval),
+		jobs: jobs.New(models.Jobs, logger, jobs.Config{
+			Workers:      settings.Jobs.Workers,
+			PollInterval: settings.Jobs.PollInterval,
+			BaseDelay:    settings.Jobs.RetryBase,
+			MaxDelay:     settings.Jobs.RetryMax,
+		}),
     }
+	app.registerJobs()
 
 	// Publish basic expvar metrics
 	expvar.NewString("version").Set(app.config.AppVersion)
@@ -12,7 +12,7 @@ func (app *applicationDependencies) Routes() http.Handler{
 	const apiv = "/api/v1"
 
 	router := httprouter.New()
-	h := handlers.NewHandler(app.config, app.logger, app.models, &app.wg, &app.mailer)
+	h := handlers.NewHandler(app.config, app.logger, app.models, app.mailer)
 
 	//* ----------------- UI file route ----------------- *//
 	// Serve static files using http.ServeMux for proper file serving
@@ -21,6 +21,10 @@ func (app *applicationDependencies) Routes() http.Handler{
 	mux.Handle("/", fs)
 	// Mount API routes under /api/v1
 	mux.Handle("/api/", router)
+	// httprouter can't have /users/password or /users/email/confirm next to
+	// /users/:id, so they are registered on the mux instead
+	mux.HandleFunc("PUT "+apiv+"/users/password", h.UpdateUserPasswordHandler)
+	mux.HandleFunc("PUT "+apiv+"/users/email/confirm", h.ConfirmEmailChangeHandler)
     
 
 	//* ----------------- General routes (public) ----------------- *//
@@ -31,7 +35,7 @@ func (app *applicationDependencies) Routes() http.Handler{
 	router.HandlerFunc(http.MethodPost, apiv+"/users", h.CreateUserHandler)
 	router.HandlerFunc(http.MethodPut, apiv+"/activate-user", h.ActivateUserHandler)
 	router.HandlerFunc(http.MethodGet, apiv+"/users", 
-		h.RequireRole("admin", h.GetAllUsersHandler))
+		h.RequirePermission("users:read", h.GetAllUsersHandler))
 
 	router.HandlerFunc(http.MethodGet, apiv+"/users/:id", 
 		h.RequireActivatedUser(h.GetUserHandler))
@@ -39,14 +43,27 @@ func (app *applicationDependencies) Routes() http.Handler{
 		h.RequireActivatedUser(h.UpdateUserHandler))
 	router.HandlerFunc(http.MethodDelete, apiv+"/users/:id", 
 		h.RequireActivatedUser(h.DeleteUserHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/users/:id/sessions", 
+		h.RequireAuthenticatedUser(h.GetUserSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, apiv+"/users/:id/sessions/:token_id", 
+		h.RequireAuthenticatedUser(h.DeleteUserSessionHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/admin/users/:id/unlock",
+		h.RequirePermission("users:manage", h.UnlockUserHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/admin/users/:id/suspend",
+		h.RequirePermission("users:manage", h.SuspendUserHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/admin/users/:id/reinstate",
+		h.RequirePermission("users:manage", h.ReinstateUserHandler))
 
 	//* ----------------- Role routes ----------------- *//
-	router.HandlerFunc(http.MethodPost, apiv+"/roles", h.RequireRole("admin", h.CreateRoleHandler))
-	router.HandlerFunc(http.MethodGet, apiv+"/roles", h.RequireRole("admin", h.GetAllRolesHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/roles", h.RequirePermission("roles:manage", h.CreateRoleHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/roles", h.RequirePermission("roles:manage", h.GetAllRolesHandler))
 
-	router.HandlerFunc(http.MethodGet, apiv+"/roles/:id", h.RequireRole("admin", h.GetRoleHandler))
-	router.HandlerFunc(http.MethodPut, apiv+"/roles/:id", h.RequireRole("admin", h.UpdateRoleHandler))
-	router.HandlerFunc(http.MethodDelete, apiv+"/roles/:id", h.RequireRole("admin", h.DeleteRoleHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/roles/:id", h.RequirePermission("roles:manage", h.GetRoleHandler))
+	router.HandlerFunc(http.MethodPut, apiv+"/roles/:id", h.RequirePermission("roles:manage", h.UpdateRoleHandler))
+	router.HandlerFunc(http.MethodDelete, apiv+"/roles/:id", h.RequirePermission("roles:manage", h.DeleteRoleHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/roles/:id/permissions", h.RequirePermission("roles:manage", h.GetRolePermissionsHandler))
+	router.HandlerFunc(http.MethodPut, apiv+"/roles/:id/permissions", h.RequirePermission("roles:manage", h.ReplaceRolePermissionsHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/permissions", h.RequirePermission("roles:manage", h.GetAllPermissionsHandler))
 
 	//* ----------------- Businesses routes ----------------- *//
 	router.HandlerFunc(http.MethodPost, apiv+"/businesses", 
@@ -58,10 +75,46 @@ func (app *applicationDependencies) Routes() http.Handler{
 		h.RequireActivatedUser(h.UpdateBusinessHandler))
 	router.HandlerFunc(http.MethodDelete, apiv+"/businesses/:id", 
 		h.RequireActivatedUser(h.DeleteBusinessHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/businesses/:id/availability", h.GetAvailabilityHandler) // public
+	router.HandlerFunc(http.MethodGet, apiv+"/businesses/:id/hours", h.GetBusinessHoursHandler) // public
+	router.HandlerFunc(http.MethodGet, apiv+"/businesses/:id/reviews", h.GetAllBusinessReviewsHandler) // public
+	router.HandlerFunc(http.MethodPut, apiv+"/businesses/:id/hours", 
+		h.RequireActivatedUser(h.ReplaceBusinessHoursHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/admin/businesses/:id/suspend",
+		h.RequirePermission("businesses:moderate", h.SuspendBusinessHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/admin/businesses/:id/reinstate",
+		h.RequirePermission("businesses:moderate", h.ReinstateBusinessHandler))
+
+	router.HandlerFunc(http.MethodPost, apiv+"/businesses/:id/time-off", 
+		h.RequireActivatedUser(h.CreateTimeOffHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/businesses/:id/time-off", 
+		h.RequireActivatedUser(h.GetAllTimeOffHandler))
+	router.HandlerFunc(http.MethodDelete, apiv+"/businesses/:id/time-off/:time_off_id", 
+		h.RequireActivatedUser(h.DeleteTimeOffHandler))
+
+	router.HandlerFunc(http.MethodPost, apiv+"/businesses/:id/staff", 
+		h.RequireActivatedUser(h.CreateStaffHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/businesses/:id/staff", h.GetAllStaffHandler) // public, contact details only for the business
+	router.HandlerFunc(http.MethodGet, apiv+"/businesses/:id/staff/:staff_id", h.GetStaffHandler) // public, contact details only for the business
+	router.HandlerFunc(http.MethodPut, apiv+"/businesses/:id/staff/:staff_id", 
+		h.RequireActivatedUser(h.UpdateStaffHandler))
+	router.HandlerFunc(http.MethodDelete, apiv+"/businesses/:id/staff/:staff_id", 
+		h.RequireActivatedUser(h.DeleteStaffHandler))
+	router.HandlerFunc(http.MethodPut, apiv+"/businesses/:id/staff/:staff_id/services", 
+		h.RequireActivatedUser(h.SetStaffServicesHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/businesses/:id/staff/:staff_id/schedule", h.GetStaffScheduleHandler) // public
+	router.HandlerFunc(http.MethodPut, apiv+"/businesses/:id/staff/:staff_id/schedule", 
+		h.RequireActivatedUser(h.ReplaceStaffScheduleHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/businesses/:id/staff/:staff_id/time-off", 
+		h.RequireActivatedUser(h.CreateStaffTimeOffHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/businesses/:id/staff/:staff_id/time-off", 
+		h.RequireActivatedUser(h.GetAllStaffTimeOffHandler))
+	router.HandlerFunc(http.MethodDelete, apiv+"/businesses/:id/staff/:staff_id/time-off/:time_off_id", 
+		h.RequireActivatedUser(h.DeleteStaffTimeOffHandler))
 		
 	//* ----------------- Services routes ----------------- *//
 	router.HandlerFunc(http.MethodPost, apiv+"/services/", 
-		h.RequireRole("business", h.CreateServiceHandler))
+		h.RequirePermission("services:write", h.CreateServiceHandler))
 	router.HandlerFunc(http.MethodGet, apiv+"/services", h.GetAllServicesHandler) // public
 	
 	router.HandlerFunc(http.MethodGet, apiv+"/services/:id", h.GetServiceHandler) // public
@@ -71,13 +124,66 @@ func (app *applicationDependencies) Routes() http.Handler{
 	h.RequireActivatedUser(h.DeleteServiceHandler))
 		
 	//* ----------------- Appointment routes ----------------- *//
-	router.HandlerFunc(http.MethodGet, apiv+"/appointments", h.GetAppointmentsHandler)
-	router.HandlerFunc(http.MethodPost, apiv+"/appointments/create", h.CreateAppointmentHandler)
+	router.HandlerFunc(http.MethodPost, apiv+"/appointments", 
+		h.RequirePermission("appointments:write", h.CreateAppointmentHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/appointments", 
+		h.RequireActivatedUser(h.GetAllAppointmentsHandler))
+
+	router.HandlerFunc(http.MethodGet, apiv+"/appointments/:id", 
+		h.RequireActivatedUser(h.GetAppointmentHandler))
+	router.HandlerFunc(http.MethodPut, apiv+"/appointments/:id", 
+		h.RequireActivatedUser(h.UpdateAppointmentHandler))
+	router.HandlerFunc(http.MethodDelete, apiv+"/appointments/:id", 
+		h.RequireActivatedUser(h.DeleteAppointmentHandler))
+
+	router.HandlerFunc(http.MethodPost, apiv+"/appointments/:id/confirm", 
+		h.RequireActivatedUser(h.ConfirmAppointmentHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/appointments/:id/cancel", 
+		h.RequireActivatedUser(h.CancelAppointmentHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/appointments/:id/complete", 
+		h.RequireActivatedUser(h.CompleteAppointmentHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/appointments/:id/no-show", 
+		h.RequireActivatedUser(h.NoShowAppointmentHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/appointments/:id/history", 
+		h.RequireActivatedUser(h.GetAppointmentHistoryHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/appointments/:id/review", 
+		h.RequireActivatedUser(h.CreateReviewHandler))
+
+	//* ----------------- Review routes ----------------- *//
+	router.HandlerFunc(http.MethodPost, apiv+"/reviews/:id/reply", 
+		h.RequireActivatedUser(h.ReplyToReviewHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/reviews/:id/flag", 
+		h.RequireActivatedUser(h.FlagReviewHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/admin/reviews", h.RequirePermission("reviews:moderate", h.GetModerationReviewsHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/admin/reviews/:id/hide", h.RequirePermission("reviews:moderate", h.HideReviewHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/admin/reviews/:id/restore", h.RequirePermission("reviews:moderate", h.RestoreReviewHandler))
+
+	//* ----------------- Email routes ----------------- *//
+	router.HandlerFunc(http.MethodGet, apiv+"/admin/emails", h.RequirePermission("emails:manage", h.GetAllEmailsHandler))
+	router.HandlerFunc(http.MethodGet, apiv+"/admin/emails/:id", h.RequirePermission("emails:manage", h.GetEmailHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/admin/emails/:id/replay", h.RequirePermission("emails:manage", h.ReplayEmailHandler))
+
+	//* ----------------- Job routes ----------------- *//
+	router.HandlerFunc(http.MethodGet, apiv+"/admin/jobs/:public_id", h.RequirePermission("jobs:read", h.GetJobHandler))
+
+	//* ----------------- MFA routes ----------------- *//
+	router.HandlerFunc(http.MethodPost, apiv+"/mfa/totp/enroll", 
+		h.RequireActivatedUser(h.EnrollTOTPHandler))
+	router.HandlerFunc(http.MethodPost, apiv+"/mfa/totp/verify", 
+		h.RequireActivatedUser(h.VerifyTOTPHandler))
+	router.HandlerFunc(http.MethodDelete, apiv+"/mfa/totp", 
+		h.RequireActivatedUser(h.DisableTOTPHandler))
 
 	//* ----------------- Token routes ----------------- *//
 	router.HandlerFunc(http.MethodPost, apiv+"/tokens/authenticate", h.CreateAuthTokenHandler)
-	router.HandlerFunc(http.MethodPost, apiv+"/tokens/activate", h.CreateActivationTokenHandler)
-	router.HandlerFunc(http.MethodDelete, apiv+"/tokens/user/:user_id", h.DeleteAllTokensForUserHandler)
+	router.HandlerFunc(http.MethodPost, apiv+"/tokens/refresh", h.RefreshAuthTokenHandler)
+	router.HandlerFunc(http.MethodPost, apiv+"/tokens/mfa", h.CreateMFATokenHandler)
+	router.HandlerFunc(http.MethodPost, apiv+"/tokens/activation", h.CreateActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, apiv+"/tokens/password-reset", h.CreatePasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodDelete, apiv+"/tokens/user/:user_id", 
+		h.RequireAuthenticatedUser(h.DeleteAllTokensForUserHandler))
+	router.HandlerFunc(http.MethodDelete, apiv+"/tokens/current", 
+		h.RequireAuthenticatedUser(h.DeleteCurrentTokenHandler))
 
 
 	// wrap mux with middleware
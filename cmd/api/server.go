@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -24,6 +25,35 @@ func (app *applicationDependencies) Serve() error {
 
 	shutdownError := make(chan error)
 
+	// deliver queued emails until the server shuts down
+	mailCtx, stopMail := context.WithCancel(context.Background())
+	mailDone := make(chan struct{})
+	go func() {
+		defer close(mailDone)
+		app.mailQueue.Run(mailCtx)
+	}()
+
+	// run background jobs until the server shuts down, and queue a cleanup
+	// every so often
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	var jobsDone sync.WaitGroup
+	jobsDone.Go(func() {
+		app.jobs.Run(jobsCtx)
+	})
+	jobsDone.Go(func() {
+		app.jobs.Every(jobsCtx, jobCleanup, cleanupPayload{RetentionDays: app.config.Jobs.RetentionDays}, app.config.Jobs.CleanupInterval)
+	})
+
+	// queue appointment reminders until the server shuts down
+	remindersCtx, stopReminders := context.WithCancel(context.Background())
+	remindersDone := make(chan struct{})
+	go func() {
+		defer close(remindersDone)
+		if app.config.Reminders.Enabled {
+			app.reminders.Run(remindersCtx)
+		}
+	}()
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -39,9 +69,19 @@ func (app *applicationDependencies) Serve() error {
 		if err != nil {
 			shutdownError <- err
 		}
-		// Wait for background tasks to complete
-		app.logger.Info("completing background tasks", "address", apiServer.Addr)
-		app.wg.Wait()
+		// reminders and jobs queue emails, so they stop before the mail workers
+		app.logger.Info("stopping reminder scheduler")
+		stopReminders()
+		<-remindersDone
+
+		app.logger.Info("stopping job workers")
+		stopJobs()
+		jobsDone.Wait()
+
+		// emails left in the queue are delivered after the next start
+		app.logger.Info("stopping mail workers")
+		stopMail()
+		<-mailDone
 		shutdownError <- nil           // successful shutdown
 		}()
 
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type ServerConfig struct {
 	Port int 
 	Environment string
@@ -13,6 +15,33 @@ type ServerConfig struct {
 	CORS struct {
 		TrustedOrigins []string
 	}
+	Lockout struct {
+		MaxAttempts int
+		Duration    time.Duration
+		BaseDelay   time.Duration
+		MaxDelay    time.Duration
+	}
+	Mail struct {
+		Driver       string
+		Dir          string
+		Workers      int
+		PollInterval time.Duration
+		MaxAttempts  int
+		RetryBase    time.Duration
+		RetryMax     time.Duration
+	}
+	Jobs struct {
+		Workers         int
+		PollInterval    time.Duration
+		RetryBase       time.Duration
+		RetryMax        time.Duration
+		CleanupInterval time.Duration
+		RetentionDays   int
+	}
+	Reminders struct {
+		Enabled  bool
+		Interval time.Duration
+	}
 	SMTP struct {
 		Host     string
 		Port     int
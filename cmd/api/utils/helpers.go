@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
 	"github.com/julienschmidt/httprouter"
@@ -121,6 +123,17 @@ func ReadIDParam(r *http.Request) (int64, error) {
 		return id, nil
 }
 
+// reads a named integer parameter (e.g. :staff_id) from the URL
+func ReadIntParam(r *http.Request, name string) (int64, error) {
+		params := httprouter.ParamsFromContext(r.Context())
+		id, err := strconv.ParseInt(params.ByName(name), 10, 64)
+		if err != nil || id < 1 {
+			return 0, fmt.Errorf("invalid %s parameter", name)
+		}
+
+		return id, nil
+}
+
 // this method can cause a validation error when trying to convert the
 // string to a valid integer value
 func GetSingleIntegerParameter( 
@@ -142,6 +155,48 @@ func GetSingleIntegerParameter(
    return intValue
 }
 
+// reads a boolean (true/false/1/0) from the query string, adding a
+// validation error if it cannot be parsed
+func GetSingleBoolParameter(
+                                 queryParameters url.Values,
+                                 key string,
+                                 defaultValue bool,
+                                 v *validator.Validator) bool {
+    result := queryParameters.Get(key)
+    if result == "" {
+        return defaultValue
+    }
+
+    boolValue, err := strconv.ParseBool(result)
+    if err != nil {
+        v.AddError(key, "must be a boolean value")
+        return defaultValue
+    }
+
+    return boolValue
+}
+
+// reads an RFC 3339 timestamp from the query string, adding a validation
+// error if it cannot be parsed
+func GetSingleTimeParameter(
+                                 queryParameters url.Values,
+                                 key string,
+                                 defaultValue time.Time,
+                                 v *validator.Validator) time.Time {
+    result := queryParameters.Get(key)
+    if result == "" {
+        return defaultValue
+    }
+
+    timeValue, err := time.Parse(time.RFC3339, result)
+    if err != nil {
+        v.AddError(key, "must be an RFC 3339 timestamp")
+        return defaultValue
+    }
+
+    return timeValue
+}
+
 func GetSingleQueryParameter( 
                                  queryParameters url.Values,
                                  key string,
@@ -172,4 +227,13 @@ func GenerateSlug(name string) string {
 	slug = strings.Trim(slug, "-")
 
 	return slug
-}
\ No newline at end of file
+}
+
+// ClientIP returns the IP address the request came from without the port
+func ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
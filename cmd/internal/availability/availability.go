@@ -0,0 +1,215 @@
+// Filename: internal/availability/availability.go
+package availability
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// Hours is an opening range on a given day of the week. Start and End are
+// offsets from midnight, so 09:00-17:00 is {Start: 9h, End: 17h}.
+type Hours struct {
+	Weekday time.Weekday
+	Start   time.Duration
+	End     time.Duration
+}
+
+// Interval is a period of time in which nothing can be booked, such as time
+// off or an existing appointment.
+type Interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Slot is a bookable appointment. StaffIDs lists the staff members that are
+// free for it when the slots of several staff members are merged.
+type Slot struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	StaffIDs []int     `json:"staff_ids,omitempty"`
+}
+
+// Request holds everything needed to work out the free slots of a business
+type Request struct {
+	From     time.Time      // earliest start time to return
+	To       time.Time      // latest end time to return
+	Hours    []Hours        // weekly opening hours
+	Busy     []Interval     // time off and existing appointments
+	Duration time.Duration  // length of the service
+	Downtime time.Duration  // time needed after the service before the next one
+	Location *time.Location // time zone of the opening hours, UTC when nil
+}
+
+// Compute returns every slot between From and To that fits inside the opening
+// hours without overlapping a busy interval. Opening hours are read in
+// Location, so the same request returns the same slots whatever the offset of
+// From and To. Slots are spaced by Duration+Downtime from the start of
+// each opening range; when a slot collides with a busy interval the next
+// candidate starts where that interval ends.
+func Compute(req Request) []Slot {
+	step := req.Duration + req.Downtime
+	if req.Duration <= 0 || !req.To.After(req.From) {
+		return []Slot{}
+	}
+
+	busy := make([]Interval, len(req.Busy))
+	copy(busy, req.Busy)
+	sort.Slice(busy, func(i, j int) bool {
+		return busy[i].Start.Before(busy[j].Start)
+	})
+
+	hours := make([]Hours, len(req.Hours))
+	copy(hours, req.Hours)
+	sort.Slice(hours, func(i, j int) bool {
+		return hours[i].Start < hours[j].Start
+	})
+
+	slots := []Slot{}
+	loc := req.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	year, month, day := req.From.In(loc).Date()
+
+	for date := time.Date(year, month, day, 0, 0, 0, 0, loc); date.Before(req.To); date = date.AddDate(0, 0, 1) {
+		for _, h := range hours {
+			if h.Weekday != date.Weekday() || h.End <= h.Start {
+				continue
+			}
+
+			open := atClock(date, h.Start)
+			closing := atClock(date, h.End)
+
+			start := open
+			for !start.Add(req.Duration).After(closing) {
+				end := start.Add(req.Duration)
+
+				// the downtime has to be free as well, even if it runs past closing
+				if conflict, ok := firstOverlap(busy, start, start.Add(step)); ok {
+					start = conflict.End.In(loc)
+					continue
+				}
+
+				if !start.Before(req.From) && !end.After(req.To) {
+					slots = append(slots, Slot{Start: start, End: end})
+				}
+				start = start.Add(step)
+			}
+		}
+	}
+
+	return slots
+}
+
+// Free reports whether an appointment starting at start fits inside the
+// opening hours without its duration or downtime overlapping a busy interval.
+// Unlike the slots Compute returns, start doesn't have to be on the grid of
+// slots. From and To of req are ignored.
+func Free(req Request, start time.Time) bool {
+	loc := req.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	start = start.In(loc)
+	end := start.Add(req.Duration)
+
+	// opening hours never run past midnight
+	if !atClock(start, 0).Equal(atClock(end, 0)) {
+		return false
+	}
+
+	// only the opening hours around the appointment are left, so the first
+	// slot Compute finds is the appointment if it fits
+	slot := Hours{
+		Weekday: start.Weekday(),
+		Start:   sinceMidnight(start),
+		End:     sinceMidnight(end),
+	}
+	req.Hours = Intersect(req.Hours, []Hours{slot})
+	req.From = atClock(start, 0)
+	req.To = end
+	req.Location = loc
+
+	slots := Compute(req)
+	return len(slots) > 0 && slots[0].Start.Equal(start)
+}
+
+// sinceMidnight returns the wall clock time of t as an offset from midnight
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+}
+
+// atClock returns the wall clock time offset from midnight on the given date.
+// Unlike date.Add(offset) it stays on the wall clock across DST changes.
+func atClock(date time.Time, offset time.Duration) time.Time {
+	year, month, day := date.Date()
+	return time.Date(year, month, day, 0, 0, 0, int(offset), date.Location())
+}
+
+// firstOverlap returns the busy interval that overlaps [start, end), if any.
+// busy must be sorted by start time.
+func firstOverlap(busy []Interval, start, end time.Time) (Interval, bool) {
+	for _, b := range busy {
+		if !b.Start.Before(end) {
+			break
+		}
+		if b.End.After(start) {
+			return b, true
+		}
+	}
+	return Interval{}, false
+}
+
+// Merge combines the slots computed for each staff member into a single list
+// ordered by start time. Slots with the same start and end are reported once
+// with every staff member that is free for them.
+func Merge(byStaff map[int][]Slot) []Slot {
+	type key struct{ start, end int64 }
+	index := map[key]int{}
+	merged := []Slot{}
+
+	for staffID, slots := range byStaff {
+		for _, slot := range slots {
+			k := key{slot.Start.UnixNano(), slot.End.UnixNano()}
+			i, ok := index[k]
+			if !ok {
+				i = len(merged)
+				index[k] = i
+				merged = append(merged, Slot{Start: slot.Start, End: slot.End})
+			}
+			merged[i].StaffIDs = append(merged[i].StaffIDs, staffID)
+		}
+	}
+
+	for i := range merged {
+		slices.Sort(merged[i].StaffIDs)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Start.Equal(merged[j].Start) {
+			return merged[i].End.Before(merged[j].End)
+		}
+		return merged[i].Start.Before(merged[j].Start)
+	})
+
+	return merged
+}
+
+// Intersect returns the parts of the opening hours in a that are also in b,
+// e.g. the hours a part-time staff member works while the business is open.
+func Intersect(a, b []Hours) []Hours {
+	hours := []Hours{}
+	for _, x := range a {
+		for _, y := range b {
+			if x.Weekday != y.Weekday {
+				continue
+			}
+			start, end := max(x.Start, y.Start), min(x.End, y.End)
+			if start < end {
+				hours = append(hours, Hours{Weekday: x.Weekday, Start: start, End: end})
+			}
+		}
+	}
+	return hours
+}
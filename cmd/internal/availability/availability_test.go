@@ -0,0 +1,359 @@
+package availability
+
+import (
+	"slices"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// monday is a Monday at midnight UTC
+var monday = time.Date(2026, time.March, 2, 0, 0, 0, 0, time.UTC)
+
+// at returns the time on the given day after monday, at hh:mm UTC
+func at(day, hh, mm int) time.Time {
+	return monday.AddDate(0, 0, day).Add(time.Duration(hh)*time.Hour + time.Duration(mm)*time.Minute)
+}
+
+func clock(hh, mm int) time.Duration {
+	return time.Duration(hh)*time.Hour + time.Duration(mm)*time.Minute
+}
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestCompute(t *testing.T) {
+	nineToNoon := []Hours{{Weekday: time.Monday, Start: clock(9, 0), End: clock(12, 0)}}
+
+	tests := []struct {
+		name string
+		req  Request
+		want []time.Time // start times, every slot lasts req.Duration
+	}{
+		{
+			name: "closed",
+			req:  Request{From: at(0, 0, 0), To: at(1, 0, 0), Duration: time.Hour},
+			want: nil,
+		},
+		{
+			name: "opening hours",
+			req:  Request{From: at(0, 0, 0), To: at(1, 0, 0), Hours: nineToNoon, Duration: time.Hour},
+			want: []time.Time{at(0, 9, 0), at(0, 10, 0), at(0, 11, 0)},
+		},
+		{
+			name: "other weekday",
+			req: Request{
+				From:     at(0, 0, 0),
+				To:       at(1, 0, 0),
+				Hours:    []Hours{{Weekday: time.Tuesday, Start: clock(9, 0), End: clock(12, 0)}},
+				Duration: time.Hour,
+			},
+			want: nil,
+		},
+		{
+			name: "several days",
+			req: Request{
+				From: at(0, 0, 0),
+				To:   at(2, 0, 0),
+				Hours: []Hours{
+					{Weekday: time.Tuesday, Start: clock(9, 0), End: clock(10, 0)},
+					{Weekday: time.Monday, Start: clock(14, 0), End: clock(15, 0)},
+					{Weekday: time.Monday, Start: clock(9, 0), End: clock(10, 0)},
+				},
+				Duration: time.Hour,
+			},
+			want: []time.Time{at(0, 9, 0), at(0, 14, 0), at(1, 9, 0)},
+		},
+		{
+			name: "downtime spaces slots",
+			req:  Request{From: at(0, 0, 0), To: at(1, 0, 0), Hours: nineToNoon, Duration: time.Hour, Downtime: 15 * time.Minute},
+			want: []time.Time{at(0, 9, 0), at(0, 10, 15)},
+		},
+		{
+			name: "downtime may run past closing",
+			req: Request{
+				From:     at(0, 0, 0),
+				To:       at(1, 0, 0),
+				Hours:    []Hours{{Weekday: time.Monday, Start: clock(9, 0), End: clock(10, 0)}},
+				Duration: time.Hour,
+				Downtime: 30 * time.Minute,
+			},
+			want: []time.Time{at(0, 9, 0)},
+		},
+		{
+			name: "downtime must not overlap busy time",
+			req: Request{
+				From:     at(0, 0, 0),
+				To:       at(1, 0, 0),
+				Hours:    nineToNoon,
+				Busy:     []Interval{{Start: at(0, 10, 0), End: at(0, 10, 30)}},
+				Duration: time.Hour,
+				Downtime: 30 * time.Minute,
+			},
+			want: []time.Time{at(0, 10, 30)},
+		},
+		{
+			name: "time off",
+			req: Request{
+				From:     at(0, 0, 0),
+				To:       at(1, 0, 0),
+				Hours:    nineToNoon,
+				Busy:     []Interval{{Start: at(0, 10, 0), End: at(0, 10, 30)}},
+				Duration: time.Hour,
+			},
+			want: []time.Time{at(0, 9, 0), at(0, 10, 30)},
+		},
+		{
+			name: "time off covering the day",
+			req: Request{
+				From:     at(0, 0, 0),
+				To:       at(1, 0, 0),
+				Hours:    nineToNoon,
+				Busy:     []Interval{{Start: at(-1, 12, 0), End: at(1, 0, 0)}},
+				Duration: time.Hour,
+			},
+			want: nil,
+		},
+		{
+			name: "booked ranges",
+			req: Request{
+				From:  at(0, 0, 0),
+				To:    at(1, 0, 0),
+				Hours: nineToNoon,
+				// unsorted on purpose
+				Busy: []Interval{
+					{Start: at(0, 11, 0), End: at(0, 11, 30)},
+					{Start: at(0, 9, 30), End: at(0, 10, 0)},
+				},
+				Duration: time.Hour,
+			},
+			want: []time.Time{at(0, 10, 0)},
+		},
+		{
+			name: "busy interval touching a slot",
+			req: Request{
+				From:     at(0, 0, 0),
+				To:       at(1, 0, 0),
+				Hours:    nineToNoon,
+				Busy:     []Interval{{Start: at(0, 8, 0), End: at(0, 9, 0)}, {Start: at(0, 10, 0), End: at(0, 11, 0)}},
+				Duration: time.Hour,
+			},
+			want: []time.Time{at(0, 9, 0), at(0, 11, 0)},
+		},
+		{
+			name: "slots inside the range only",
+			req:  Request{From: at(0, 9, 30), To: at(0, 11, 30), Hours: nineToNoon, Duration: time.Hour},
+			want: []time.Time{at(0, 10, 0)},
+		},
+		{
+			name: "slots may touch the range boundaries",
+			req:  Request{From: at(0, 10, 0), To: at(0, 12, 0), Hours: nineToNoon, Duration: time.Hour},
+			want: []time.Time{at(0, 10, 0), at(0, 11, 0)},
+		},
+		{
+			name: "service longer than opening hours",
+			req:  Request{From: at(0, 0, 0), To: at(1, 0, 0), Hours: nineToNoon, Duration: 4 * time.Hour},
+			want: nil,
+		},
+		{
+			name: "no duration",
+			req:  Request{From: at(0, 0, 0), To: at(1, 0, 0), Hours: nineToNoon},
+			want: nil,
+		},
+		{
+			name: "to before from",
+			req:  Request{From: at(1, 0, 0), To: at(0, 0, 0), Hours: nineToNoon, Duration: time.Hour},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Compute(tt.req)
+			assertStarts(t, got, tt.want, tt.req.Duration)
+		})
+	}
+}
+
+func TestComputeLocation(t *testing.T) {
+	belize := mustLoad(t, "America/Belize") // UTC-6 all year
+	hours := []Hours{{Weekday: time.Monday, Start: clock(9, 0), End: clock(10, 0)}}
+	want := []time.Time{at(0, 15, 0)}
+
+	// the same range written with different offsets gives the same slots
+	for _, from := range []time.Time{at(0, 0, 0), at(0, 0, 0).In(belize), at(0, 0, 0).In(time.FixedZone("", 9*60*60))} {
+		req := Request{From: from, To: from.Add(24 * time.Hour), Hours: hours, Duration: time.Hour, Location: belize}
+		got := Compute(req)
+		assertStarts(t, got, want, req.Duration)
+
+		for _, slot := range got {
+			if slot.Start.Location() != belize {
+				t.Errorf("slot starts in %s, want %s", slot.Start.Location(), belize)
+			}
+		}
+	}
+}
+
+func TestComputeDST(t *testing.T) {
+	newYork := mustLoad(t, "America/New_York")
+
+	// clocks go forward at 02:00 on Sunday March 8 2026
+	sunday := time.Date(2026, time.March, 8, 0, 0, 0, 0, newYork)
+	req := Request{
+		From:     sunday,
+		To:       sunday.AddDate(0, 0, 1),
+		Hours:    []Hours{{Weekday: time.Sunday, Start: clock(9, 0), End: clock(10, 0)}},
+		Duration: time.Hour,
+		Location: newYork,
+	}
+
+	// 09:00 EDT, not 10:00 EDT
+	want := []time.Time{time.Date(2026, time.March, 8, 13, 0, 0, 0, time.UTC)}
+	assertStarts(t, Compute(req), want, req.Duration)
+}
+
+func TestFree(t *testing.T) {
+	belize := mustLoad(t, "America/Belize")
+	hours := []Hours{
+		{Weekday: time.Monday, Start: clock(9, 0), End: clock(12, 0)},
+		{Weekday: time.Monday, Start: clock(13, 0), End: clock(17, 0)},
+	}
+	req := Request{
+		Hours:    hours,
+		Busy:     []Interval{{Start: at(0, 15, 0), End: at(0, 16, 0)}},
+		Duration: time.Hour,
+		Downtime: 15 * time.Minute,
+	}
+
+	tests := []struct {
+		name  string
+		start time.Time
+		want  bool
+	}{
+		{name: "on the grid", start: at(0, 9, 0), want: true},
+		{name: "off the grid", start: at(0, 9, 10), want: true},
+		{name: "ending at closing", start: at(0, 11, 0), want: true},
+		{name: "running past closing", start: at(0, 11, 30), want: false},
+		{name: "over the lunch break", start: at(0, 12, 30), want: false},
+		{name: "before opening", start: at(0, 8, 30), want: false},
+		{name: "closed day", start: at(-1, 10, 0), want: false},
+		{name: "busy", start: at(0, 15, 30), want: false},
+		{name: "downtime overlapping busy time", start: at(0, 13, 50), want: false},
+		{name: "right after busy time", start: at(0, 16, 0), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Free(req, tt.start); got != tt.want {
+				t.Errorf("Free(%s) = %v, want %v", tt.start, got, tt.want)
+			}
+		})
+	}
+
+	// 09:00 in Belize is 15:00 UTC
+	local := req
+	local.Busy = nil
+	local.Location = belize
+	if Free(local, at(0, 9, 0)) {
+		t.Errorf("09:00 UTC is free in Belize, want closed")
+	}
+	if !Free(local, at(0, 15, 0)) {
+		t.Errorf("15:00 UTC is closed in Belize, want free")
+	}
+}
+
+func assertStarts(t *testing.T, got []Slot, want []time.Time, duration time.Duration) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d slots %v, want %d %v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if !got[i].Start.Equal(want[i]) || !got[i].End.Equal(want[i].Add(duration)) {
+			t.Errorf("slot %d: got %s-%s, want %s-%s", i, got[i].Start, got[i].End, want[i], want[i].Add(duration))
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	byStaff := map[int][]Slot{
+		2: {{Start: at(0, 10, 0), End: at(0, 11, 0)}, {Start: at(0, 9, 0), End: at(0, 10, 0)}},
+		1: {{Start: at(0, 9, 0), End: at(0, 10, 0)}},
+		3: {{Start: at(0, 9, 0), End: at(0, 9, 30)}},
+	}
+
+	want := []Slot{
+		{Start: at(0, 9, 0), End: at(0, 9, 30), StaffIDs: []int{3}},
+		{Start: at(0, 9, 0), End: at(0, 10, 0), StaffIDs: []int{1, 2}},
+		{Start: at(0, 10, 0), End: at(0, 11, 0), StaffIDs: []int{2}},
+	}
+
+	got := Merge(byStaff)
+	if len(got) != len(want) {
+		t.Fatalf("got %d slots %v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if !got[i].Start.Equal(want[i].Start) || !got[i].End.Equal(want[i].End) || !slices.Equal(got[i].StaffIDs, want[i].StaffIDs) {
+			t.Errorf("slot %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []Hours
+		want []Hours
+	}{
+		{
+			name: "staff works part of the opening hours",
+			a:    []Hours{{Weekday: time.Monday, Start: clock(9, 0), End: clock(17, 0)}},
+			b:    []Hours{{Weekday: time.Monday, Start: clock(12, 0), End: clock(20, 0)}},
+			want: []Hours{{Weekday: time.Monday, Start: clock(12, 0), End: clock(17, 0)}},
+		},
+		{
+			name: "different days",
+			a:    []Hours{{Weekday: time.Monday, Start: clock(9, 0), End: clock(17, 0)}},
+			b:    []Hours{{Weekday: time.Tuesday, Start: clock(9, 0), End: clock(17, 0)}},
+			want: []Hours{},
+		},
+		{
+			name: "ranges only touching",
+			a:    []Hours{{Weekday: time.Monday, Start: clock(9, 0), End: clock(12, 0)}},
+			b:    []Hours{{Weekday: time.Monday, Start: clock(12, 0), End: clock(17, 0)}},
+			want: []Hours{},
+		},
+		{
+			name: "split shift",
+			a:    []Hours{{Weekday: time.Monday, Start: clock(9, 0), End: clock(17, 0)}},
+			b: []Hours{
+				{Weekday: time.Monday, Start: clock(8, 0), End: clock(11, 0)},
+				{Weekday: time.Monday, Start: clock(15, 0), End: clock(18, 0)},
+			},
+			want: []Hours{
+				{Weekday: time.Monday, Start: clock(9, 0), End: clock(11, 0)},
+				{Weekday: time.Monday, Start: clock(15, 0), End: clock(17, 0)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Intersect(tt.a, tt.b)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %v, want %v", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
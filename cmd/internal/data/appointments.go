@@ -1,14 +1,695 @@
 package data
 
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+	"github.com/lib/pq"
+)
+
 type Appointment struct {
-	ID    int    `json:"id"`
-	BusinessID int    `json:"business_id"`
-	BusinessName string `json:"business_name,omitempty"`
-	ServiceID int    `json:"service_id"`
-	ServiceName string `json:"service_name,omitempty"`
-	BusinessStaffID int    `json:"business_staff_id"`
-	BusinessStaffName string `json:"business_staff_name,omitempty"`
-	CustomerID int    `json:"customer_id"`
-	CustomerName string `json:"customer_name,omitempty"`
-	Date  string `json:"date"`
-}
\ No newline at end of file
+	ID                int               `json:"id"`
+	BusinessID        int               `json:"business_id"`
+	BusinessName      string            `json:"business_name,omitempty"`
+	BusinessOwnerID   int               `json:"-"`
+	BusinessEmail     string            `json:"-"`
+	BusinessTimezone  string            `json:"-"`
+	ServiceID         int               `json:"service_id"`
+	ServiceName       string            `json:"service_name,omitempty"`
+	BusinessStaffID   int               `json:"business_staff_id,omitempty"`
+	BusinessStaffName string            `json:"business_staff_name,omitempty"`
+	CustomerID        int               `json:"customer_id"`
+	CustomerName      string            `json:"customer_name,omitempty"`
+	CustomerEmail     string            `json:"-"`
+	Name              string            `json:"name,omitempty"`
+	Notes             string            `json:"notes,omitempty"`
+	StartTime         time.Time         `json:"start_time"`
+	EndTime           time.Time         `json:"end_time"`
+	Status            AppointmentStatus `json:"status"`
+	UID               string            `json:"uid"`
+	Sequence          int               `json:"-"`
+	CreatedAt         time.Time         `json:"created_at"`
+	UpdatedAt         *time.Time        `json:"updated_at,omitempty"`
+}
+
+// Location is the time zone of the business, appointment times in emails
+// read in it
+func (a *Appointment) Location() *time.Location {
+	return loadLocation(a.BusinessTimezone)
+}
+
+// NewAppointmentUID returns a UID for the calendar invites of a new
+// appointment. It never changes, so calendars can tell which event an
+// invite is about.
+func NewAppointmentUID() string {
+	return strings.ToLower(rand.Text()) + "@lockit-appointments"
+}
+
+type AppointmentStatus string
+
+const (
+	AppointmentStatusPending   AppointmentStatus = "pending"
+	AppointmentStatusConfirmed AppointmentStatus = "confirmed"
+	AppointmentStatusCancelled AppointmentStatus = "cancelled"
+	AppointmentStatusCompleted AppointmentStatus = "completed"
+	AppointmentStatusNoShow    AppointmentStatus = "no_show"
+)
+
+// appointmentTransitions is the central table of the status changes an
+// appointment may go through. cancelled, completed and no_show are final.
+var appointmentTransitions = map[AppointmentStatus][]AppointmentStatus{
+	AppointmentStatusPending:   {AppointmentStatusConfirmed, AppointmentStatusCancelled},
+	AppointmentStatusConfirmed: {AppointmentStatusCompleted, AppointmentStatusNoShow, AppointmentStatusCancelled},
+}
+
+var ErrInvalidTransition = errors.New("invalid appointment status transition")
+
+// CanTransitionTo reports whether an appointment may move from s to next
+func (s AppointmentStatus) CanTransitionTo(next AppointmentStatus) bool {
+	return slices.Contains(appointmentTransitions[s], next)
+}
+
+// IsFinal reports whether no further status changes are allowed
+func (s AppointmentStatus) IsFinal() bool {
+	return len(appointmentTransitions[s]) == 0
+}
+
+// StatusChange records who moved an appointment between two statuses and when
+type StatusChange struct {
+	ID            int               `json:"id"`
+	AppointmentID int               `json:"appointment_id"`
+	FromStatus    AppointmentStatus `json:"from_status"`
+	ToStatus      AppointmentStatus `json:"to_status"`
+	ChangedBy     *int              `json:"changed_by"`
+	ChangedAt     time.Time         `json:"changed_at"`
+}
+
+// TimeRange is a booked period of time
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ErrSlotTaken is returned when an appointment overlaps another live
+// appointment. The appointments_no_overlap constraint enforces this in the
+// database so concurrent bookings can't both succeed.
+var ErrSlotTaken = errors.New("time slot already taken")
+
+func isSlotTakenError(err error) bool {
+	return strings.Contains(err.Error(), "violates exclusion constraint") && strings.Contains(err.Error(), "appointments_no_overlap")
+}
+
+type AppointmentModel struct {
+	DB *sql.DB
+}
+
+func ValidateAppointment(v *validator.Validator, appointment *Appointment) {
+	v.Check(appointment.BusinessID > 0, "business_id", "must be provided")
+	v.Check(appointment.ServiceID > 0, "service_id", "must be provided")
+	v.Check(appointment.CustomerID > 0, "customer_id", "must be provided")
+
+	v.Check(len(appointment.Name) <= 200, "name", "must not be more than 200 characters long")
+	v.Check(len(appointment.Notes) <= 500, "notes", "must not be more than 500 characters long")
+
+	v.Check(!appointment.StartTime.IsZero(), "start_time", "must be provided")
+	v.Check(appointment.EndTime.After(appointment.StartTime), "end_time", "must be after start_time")
+}
+
+// nullableID stores a zero foreign key as NULL
+func nullableID(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+const appointmentColumns = `
+		a.id,
+		a.business_id,
+		b.name AS business_name,
+		b.owner_id,
+		b.email AS business_email,
+		b.timezone,
+		a.service_id,
+		s.name AS service_name,
+		COALESCE(a.business_staff_id, 0),
+		COALESCE(st.name, ''),
+		a.customer_id,
+		u.username AS customer_name,
+		u.email AS customer_email,
+		COALESCE(a.name, ''),
+		COALESCE(a.notes, ''),
+		a.start_time,
+		a.end_time,
+		a.status,
+		a.uid,
+		a.calendar_sequence,
+		a.created_at,
+		a.updated_at`
+
+const appointmentJoins = `
+		FROM appointments a
+		JOIN businesses b ON a.business_id = b.id
+		JOIN services s ON a.service_id = s.id
+		JOIN users u ON a.customer_id = u.id
+		LEFT JOIN business_staff st ON a.business_staff_id = st.id`
+
+func scanAppointment(row scanner, appointment *Appointment, extra ...any) error {
+	dest := append(extra,
+		&appointment.ID,
+		&appointment.BusinessID,
+		&appointment.BusinessName,
+		&appointment.BusinessOwnerID,
+		&appointment.BusinessEmail,
+		&appointment.BusinessTimezone,
+		&appointment.ServiceID,
+		&appointment.ServiceName,
+		&appointment.BusinessStaffID,
+		&appointment.BusinessStaffName,
+		&appointment.CustomerID,
+		&appointment.CustomerName,
+		&appointment.CustomerEmail,
+		&appointment.Name,
+		&appointment.Notes,
+		&appointment.StartTime,
+		&appointment.EndTime,
+		&appointment.Status,
+		&appointment.UID,
+		&appointment.Sequence,
+		&appointment.CreatedAt,
+		&appointment.UpdatedAt,
+	)
+	return row.Scan(dest...)
+}
+
+// Insert saves a new appointment. Emails about the booking are queued in the
+// same transaction.
+func (a *AppointmentModel) Insert(appointment *Appointment, emails ...*QueuedEmail) (*Appointment, error) {
+	if appointment.UID == "" {
+		appointment.UID = NewAppointmentUID()
+	}
+
+	query := `
+		INSERT INTO appointments (business_id, service_id, business_staff_id, customer_id, name, notes, start_time, end_time, status, uid)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, calendar_sequence, created_at
+	`
+
+	args := []interface{}{
+		appointment.BusinessID,
+		appointment.ServiceID,
+		nullableID(appointment.BusinessStaffID),
+		appointment.CustomerID,
+		appointment.Name,
+		appointment.Notes,
+		appointment.StartTime,
+		appointment.EndTime,
+		appointment.Status,
+		appointment.UID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&appointment.ID, &appointment.Sequence, &appointment.CreatedAt)
+	if err != nil {
+		if isSlotTakenError(err) {
+			return nil, ErrSlotTaken
+		}
+		return nil, err
+	}
+
+	err = enqueueEmails(ctx, tx, emails...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return appointment, nil
+}
+
+func (a *AppointmentModel) Get(id int) (*Appointment, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + appointmentColumns + appointmentJoins + `
+		WHERE a.id = $1`
+
+	var appointment Appointment
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanAppointment(a.DB.QueryRowContext(ctx, query, id), &appointment)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &appointment, nil
+}
+
+// GetAll returns the appointments matching the given customer and business.
+// A zero ID means that column is not filtered on.
+func (a *AppointmentModel) GetAll(customerID int, businessID int, filters Filters) ([]*Appointment, Metadata, error) {
+	query := `
+		SELECT count(*) OVER() AS total_count,` + appointmentColumns + appointmentJoins + `
+		WHERE ($1 = 0 OR a.customer_id = $1)
+		AND ($2 = 0 OR a.business_id = $2)
+		ORDER BY a.` + filters.sortColumn() + ` ` + filters.sortDirection() + `, a.id ASC
+		LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, customerID, businessID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	appointments := []*Appointment{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var appointment Appointment
+
+		err := scanAppointment(rows, &appointment, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		appointments = append(appointments, &appointment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return appointments, metadata, nil
+}
+
+// Update saves the details of an appointment. The status is left alone, it
+// can only be changed through UpdateStatus. Every change moves the calendar
+// sequence on, and the update only applies if nobody changed the appointment
+// in the meantime, otherwise ErrEditConflict is returned. Emails about the
+// change are queued in the same transaction. A new start time forgets the
+// reminders sent for the old one, so the customer is reminded again.
+func (a *AppointmentModel) Update(appointment *Appointment, emails ...*QueuedEmail) error {
+	query := `
+		UPDATE appointments
+		SET service_id = $1, business_staff_id = $2, name = $3, notes = $4, start_time = $5, end_time = $6,
+		calendar_sequence = calendar_sequence + 1
+		WHERE id = $7 AND calendar_sequence = $8
+		RETURNING calendar_sequence, updated_at
+	`
+
+	args := []interface{}{
+		appointment.ServiceID,
+		nullableID(appointment.BusinessStaffID),
+		appointment.Name,
+		appointment.Notes,
+		appointment.StartTime,
+		appointment.EndTime,
+		appointment.ID,
+		appointment.Sequence,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previousStart time.Time
+	err = tx.QueryRowContext(ctx, `SELECT start_time FROM appointments WHERE id = $1 FOR UPDATE`, appointment.ID).Scan(&previousStart)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&appointment.Sequence, &appointment.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isSlotTakenError(err):
+			return ErrSlotTaken
+		default:
+			return err
+		}
+	}
+
+	if !appointment.StartTime.Equal(previousStart) {
+		_, err = tx.ExecContext(ctx, `DELETE FROM appointment_reminders WHERE appointment_id = $1`, appointment.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = enqueueEmails(ctx, tx, emails...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes an appointment. It only applies if nobody changed the
+// appointment in the meantime, otherwise ErrEditConflict is returned. Emails
+// about the removal are queued in the same transaction.
+func (a *AppointmentModel) Delete(appointment *Appointment, emails ...*QueuedEmail) error {
+	if appointment.ID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM appointments
+		WHERE id = $1 AND calendar_sequence = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, appointment.ID, appointment.Sequence)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	err = enqueueEmails(ctx, tx, emails...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateStatus moves the appointment to the next status and records the
+// change in appointment_status_history. Like Update it moves the calendar
+// sequence on and only applies if nobody changed the appointment in the
+// meantime, otherwise ErrEditConflict is returned. Emails about the change
+// are queued in the same transaction.
+func (a *AppointmentModel) UpdateStatus(appointment *Appointment, next AppointmentStatus, changedBy int, emails ...*QueuedEmail) error {
+	if !appointment.Status.CanTransitionTo(next) {
+		return ErrInvalidTransition
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateStatus(ctx, tx, appointment, next, changedBy)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueEmails(ctx, tx, emails...)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	appointment.Status = next
+	return nil
+}
+
+// updateStatus moves the appointment to the next status in tx and records
+// the change. appointment.Status is left for the caller to set once tx is
+// committed.
+func updateStatus(ctx context.Context, tx *sql.Tx, appointment *Appointment, next AppointmentStatus, changedBy int) error {
+	query := `
+		UPDATE appointments
+		SET status = $1, calendar_sequence = calendar_sequence + 1
+		WHERE id = $2 AND status = $3 AND calendar_sequence = $4
+		RETURNING calendar_sequence, updated_at
+	`
+
+	err := tx.QueryRowContext(ctx, query, next, appointment.ID, appointment.Status, appointment.Sequence).Scan(&appointment.Sequence, &appointment.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case isSlotTakenError(err):
+			return ErrSlotTaken
+		default:
+			return err
+		}
+	}
+
+	query = `
+		INSERT INTO appointment_status_history (appointment_id, from_status, to_status, changed_by)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err = tx.ExecContext(ctx, query, appointment.ID, appointment.Status, next, changedBy)
+	return err
+}
+
+// Cancellation is an appointment that is cancelled as part of another
+// change, such as new time off, with the emails that tell both sides
+type Cancellation struct {
+	Appointment *Appointment
+	Emails      []*QueuedEmail
+}
+
+// cancelAppointments cancels the appointments in tx and queues their emails.
+// It returns ErrEditConflict if any of them changed since it was read, so
+// either all of them are cancelled or none. The appointments only show as
+// cancelled once tx is committed, see cancelled.
+func cancelAppointments(ctx context.Context, tx *sql.Tx, changedBy int, cancellations []*Cancellation) error {
+	for _, c := range cancellations {
+		if !c.Appointment.Status.CanTransitionTo(AppointmentStatusCancelled) {
+			return ErrEditConflict
+		}
+
+		err := updateStatus(ctx, tx, c.Appointment, AppointmentStatusCancelled, changedBy)
+		if err != nil {
+			return err
+		}
+
+		err = enqueueEmails(ctx, tx, c.Emails...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cancelled marks the appointments of committed cancellations as cancelled
+func cancelled(cancellations []*Cancellation) {
+	for _, c := range cancellations {
+		c.Appointment.Status = AppointmentStatusCancelled
+	}
+}
+
+// GetStatusHistory returns the status changes of an appointment, oldest first
+func (a *AppointmentModel) GetStatusHistory(appointmentID int) ([]*StatusChange, error) {
+	query := `
+		SELECT id, appointment_id, from_status, to_status, changed_by, changed_at
+		FROM appointment_status_history
+		WHERE appointment_id = $1
+		ORDER BY changed_at, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, appointmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []*StatusChange{}
+
+	for rows.Next() {
+		var change StatusChange
+
+		err := rows.Scan(
+			&change.ID,
+			&change.AppointmentID,
+			&change.FromStatus,
+			&change.ToStatus,
+			&change.ChangedBy,
+			&change.ChangedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, &change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// GetOverlapping returns the appointments of a business with one of the
+// given statuses that overlap [from, to)
+func (a *AppointmentModel) GetOverlapping(businessID int, from, to time.Time, statuses ...AppointmentStatus) ([]*Appointment, error) {
+	query := `
+		SELECT ` + appointmentColumns + appointmentJoins + `
+		WHERE a.business_id = $1
+		AND a.status = ANY($4)
+		AND a.start_time < $3
+		AND a.end_time > $2
+		ORDER BY a.start_time`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	names := make([]string, len(statuses))
+	for i, status := range statuses {
+		names[i] = string(status)
+	}
+
+	rows, err := a.DB.QueryContext(ctx, query, businessID, from, to, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appointments := []*Appointment{}
+
+	for rows.Next() {
+		var appointment Appointment
+
+		err := scanAppointment(rows, &appointment)
+		if err != nil {
+			return nil, err
+		}
+
+		appointments = append(appointments, &appointment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return appointments, nil
+}
+
+// GetBookedRanges returns the time taken up by the non-cancelled appointments
+// of a staff member that overlap [from, to). A staffID of 0 means the
+// appointments booked with the business rather than a staff member. Each
+// range is extended by the downtime of the booked service. The appointment
+// exceptID is left out, so it doesn't get in the way of its own reschedule.
+func (a *AppointmentModel) GetBookedRanges(businessID int, staffID int, from, to time.Time, exceptID int) ([]TimeRange, error) {
+	query := `
+		SELECT a.start_time,
+		a.end_time + make_interval(mins => COALESCE(s.downtime_mins, 0))
+		FROM appointments a
+		JOIN services s ON a.service_id = s.id
+		WHERE a.business_id = $1
+		AND COALESCE(a.business_staff_id, 0) = $4
+		AND a.status <> 'cancelled'
+		AND a.start_time < $3
+		AND a.end_time + make_interval(mins => COALESCE(s.downtime_mins, 0)) > $2
+		AND a.id <> $5
+		ORDER BY a.start_time`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := a.DB.QueryContext(ctx, query, businessID, from, to, staffID, exceptID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ranges := []TimeRange{}
+
+	for rows.Next() {
+		var booked TimeRange
+
+		err := rows.Scan(&booked.Start, &booked.End)
+		if err != nil {
+			return nil, err
+		}
+
+		ranges = append(ranges, booked)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ranges, nil
+}
+
+// IsBusinessSide reports whether the user acts for the business of the
+// appointment, i.e. is its owner or may manage any business.
+func (a *AppointmentModel) IsBusinessSide(currentUser *User, permissions Permissions, appointment *Appointment) bool {
+	return permissions.Include("businesses:manage") || appointment.BusinessOwnerID == currentUser.ID
+}
+
+// CanAccessAppointmentData reports whether the user may view or change the
+// appointment: the customer who booked it and the business side.
+func (a *AppointmentModel) CanAccessAppointmentData(currentUser *User, permissions Permissions, appointment *Appointment) bool {
+	if permissions.Include("businesses:manage") {
+		return true
+	}
+
+	return appointment.CustomerID == currentUser.ID || appointment.BusinessOwnerID == currentUser.ID
+}
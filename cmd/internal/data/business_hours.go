@@ -0,0 +1,162 @@
+// Filename: internal/data/business_hours.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// BusinessHours is one opening range of a business on a day of the week.
+// day_of_week follows time.Weekday, so 0 is Sunday.
+type BusinessHours struct {
+	ID             int        `json:"id"`
+	BusinessID     int        `json:"business_id"`
+	DayOfWeek      int        `json:"day_of_week"`
+	StartTime      string     `json:"start_time"` // HH:MM
+	EndTime        string     `json:"end_time"`   // HH:MM
+	ClosedForLunch bool       `json:"closed_for_lunch"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+type BusinessHoursModel struct {
+	DB *sql.DB
+}
+
+// ParseClock converts an HH:MM time of day into an offset from midnight
+func ParseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ValidateBusinessHours checks a full weekly schedule. Every range must be on
+// a valid day, end after it starts and not overlap another range of that day.
+func ValidateBusinessHours(v *validator.Validator, hours []*BusinessHours) {
+	type span struct {
+		index      int
+		start, end time.Duration
+	}
+	days := map[int][]span{}
+
+	for i, h := range hours {
+		key := fmt.Sprintf("hours[%d]", i)
+
+		v.Check(h.DayOfWeek >= 0 && h.DayOfWeek <= 6, key+".day_of_week", "must be between 0 (Sunday) and 6 (Saturday)")
+
+		start, err := ParseClock(h.StartTime)
+		v.Check(err == nil, key+".start_time", "must be a time in HH:MM format")
+		end, err2 := ParseClock(h.EndTime)
+		v.Check(err2 == nil, key+".end_time", "must be a time in HH:MM format")
+
+		if err != nil || err2 != nil {
+			continue
+		}
+
+		v.Check(start < end, key+".end_time", "must be after start_time")
+		days[h.DayOfWeek] = append(days[h.DayOfWeek], span{index: i, start: start, end: end})
+	}
+
+	for _, spans := range days {
+		sort.Slice(spans, func(i, j int) bool {
+			return spans[i].start < spans[j].start
+		})
+		for i := 1; i < len(spans); i++ {
+			v.Check(spans[i].start >= spans[i-1].end,
+				fmt.Sprintf("hours[%d]", spans[i].index),
+				fmt.Sprintf("overlaps hours[%d] on the same day", spans[i-1].index))
+		}
+	}
+}
+
+// GetAllForBusiness returns the weekly schedule of a business ordered by day
+// and start time
+func (b *BusinessHoursModel) GetAllForBusiness(businessID int) ([]*BusinessHours, error) {
+	query := `
+		SELECT id, business_id, day_of_week,
+		to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'),
+		COALESCE(closed_for_lunch, FALSE), created_at, updated_at
+		FROM business_hours
+		WHERE business_id = $1
+		ORDER BY day_of_week, start_time`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := b.DB.QueryContext(ctx, query, businessID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hours := []*BusinessHours{}
+
+	for rows.Next() {
+		var h BusinessHours
+
+		err := rows.Scan(
+			&h.ID,
+			&h.BusinessID,
+			&h.DayOfWeek,
+			&h.StartTime,
+			&h.EndTime,
+			&h.ClosedForLunch,
+			&h.CreatedAt,
+			&h.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		hours = append(hours, &h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hours, nil
+}
+
+// ReplaceForBusiness swaps the whole weekly schedule of a business for the
+// given hours in a single transaction
+func (b *BusinessHoursModel) ReplaceForBusiness(businessID int, hours []*BusinessHours) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM business_hours WHERE business_id = $1`, businessID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO business_hours (business_id, day_of_week, start_time, end_time, closed_for_lunch)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	for _, h := range hours {
+		h.BusinessID = businessID
+		args := []interface{}{h.BusinessID, h.DayOfWeek, h.StartTime, h.EndTime, h.ClosedForLunch}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&h.ID, &h.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/Lee26Ed/lockit_appointments/cmd/api/utils"
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+	"github.com/lib/pq"
 )
 
 type Business struct {
@@ -21,6 +23,12 @@ type Business struct {
 	LogoURL string `json:"logo_url,omitempty"`
 	Slug string `json:"slug"`
 	Status BusinessStatus `json:"status"`
+	ReminderOffsets []int64 `json:"reminder_offsets"`
+	Timezone string `json:"timezone"`
+	SuspendedReason string `json:"suspended_reason,omitempty"`
+	SuspendedAt *time.Time `json:"suspended_at,omitempty"`
+	AverageRating float64 `json:"average_rating"`
+	ReviewCount int `json:"review_count"`
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt *time.Time `json:"updated_at,omitempty"`
 }
@@ -46,6 +54,36 @@ func ValidateBusiness(v *validator.Validator, business *Business) {
 	ValidateEmail(v, business.Email)
 
 	v.Check(business.OwnerID != 0, "owner_id", "must not be empty")
+
+	ValidateReminderOffsets(v, business.ReminderOffsets)
+
+	_, err := time.LoadLocation(business.Timezone)
+	v.Check(business.Timezone != "" && business.Timezone != "Local" && err == nil, "timezone", "must be a valid IANA time zone")
+}
+
+// Location is the time zone the opening hours of the business are in
+func (b *Business) Location() *time.Location {
+	return loadLocation(b.Timezone)
+}
+
+// loadLocation falls back to UTC for time zones that can't be loaded
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// ValidateReminderOffsets checks the hours before an appointment at which
+// the customer is reminded of it. No reminders at all is fine.
+func ValidateReminderOffsets(v *validator.Validator, offsets []int64) {
+	v.Check(len(offsets) <= 5, "reminder_offsets", "must not contain more than 5 entries")
+
+	for i, hours := range offsets {
+		v.Check(hours >= 1 && hours <= 168, "reminder_offsets", "must be between 1 and 168 hours")
+		v.Check(!slices.Contains(offsets[:i], hours), "reminder_offsets", "must not contain duplicate entries")
+	}
 }
 
 var ErrDuplicateSlug = errors.New("duplicate slug")
@@ -92,9 +130,9 @@ func (b *BusinessModel) GenerateUniqueSlug(name string) (string, error) {
 
 func (b *BusinessModel) Insert(business *Business) (*Business, error) {
 	query := `
-		INSERT INTO businesses (name, bio, owner_id, email, phone, logo_url, slug, status)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id, created_at
+		INSERT INTO businesses (name, bio, owner_id, email, phone, logo_url, slug, status, timezone)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, reminder_offsets, created_at
 	`
 
 	args := []interface{}{
@@ -106,12 +144,13 @@ func (b *BusinessModel) Insert(business *Business) (*Business, error) {
 		business.LogoURL,
 		business.Slug,
 		business.Status,
+		business.Timezone,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&business.ID, &business.CreatedAt)
+	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&business.ID, pq.Array(&business.ReminderOffsets), &business.CreatedAt)
 	if err != nil {
 		// detect duplicate slug error
 		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") && strings.Contains(err.Error(), "businesses_slug_key") {
@@ -126,7 +165,15 @@ func (b *BusinessModel) Insert(business *Business) (*Business, error) {
 	return business, nil
 }
 
-func (b *BusinessModel) GetAll(filters Filters) ([]*Business, Metadata, error) {
+// the average rating and number of reviews of the business in each row,
+// reviews hidden by moderators don't count
+const businessRatingColumns = `
+		(SELECT COALESCE(ROUND(AVG(r.rating), 2), 0)::float8 FROM reviews r WHERE r.business_id = businesses.id AND r.state <> 'hidden') AS average_rating,
+		(SELECT count(*) FROM reviews r WHERE r.business_id = businesses.id AND r.state <> 'hidden') AS review_count`
+
+// GetAll returns a page of businesses with the given status, or of every
+// business when status is empty
+func (b *BusinessModel) GetAll(status BusinessStatus, filters Filters) ([]*Business, Metadata, error) {
 	query := `
 		SELECT count(*) OVER() AS total_count,
 		id, 
@@ -138,16 +185,22 @@ func (b *BusinessModel) GetAll(filters Filters) ([]*Business, Metadata, error) {
 		logo_url,
 		slug,
 		status,
+		reminder_offsets,
+		timezone,
+		COALESCE(suspended_reason, ''),
+		suspended_at,
 		created_at,
-		updated_at
+		updated_at,
+		` + businessRatingColumns + `
 		FROM businesses
+		WHERE (status = $1 OR $1 = '')
 		ORDER BY ` + filters.sortColumn() + ` ` + filters.sortDirection() + `
-		LIMIT $1 OFFSET $2`
+		LIMIT $2 OFFSET $3`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := b.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	rows, err := b.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -170,8 +223,14 @@ func (b *BusinessModel) GetAll(filters Filters) ([]*Business, Metadata, error) {
 			&business.LogoURL,
 			&business.Slug,
 			&business.Status,
+			pq.Array(&business.ReminderOffsets),
+			&business.Timezone,
+			&business.SuspendedReason,
+			&business.SuspendedAt,
 			&business.CreatedAt,
 			&business.UpdatedAt,
+			&business.AverageRating,
+			&business.ReviewCount,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -194,7 +253,9 @@ func (b *BusinessModel) Get(id int) (*Business, error) {
 	}
 
 	query := `
-		SELECT id, name, bio, owner_id, email, phone, logo_url, slug, status, created_at, updated_at
+		SELECT id, name, bio, owner_id, email, phone, logo_url, slug, status, reminder_offsets, timezone,
+		COALESCE(suspended_reason, ''), suspended_at, created_at, updated_at,
+		` + businessRatingColumns + `
 		FROM businesses
 		WHERE id = $1`
 
@@ -213,8 +274,14 @@ func (b *BusinessModel) Get(id int) (*Business, error) {
 		&business.LogoURL,
 		&business.Slug,
 		&business.Status,
+		pq.Array(&business.ReminderOffsets),
+		&business.Timezone,
+		&business.SuspendedReason,
+		&business.SuspendedAt,
 		&business.CreatedAt,
 		&business.UpdatedAt,
+		&business.AverageRating,
+		&business.ReviewCount,
 	)
 
 	if err != nil {
@@ -235,7 +302,7 @@ func (b *BusinessModel) GetByOwnerID(ownerID int) (*Business, error) {
 	}
 
 	query := `
-		SELECT id, name, bio, owner_id, email, phone, logo_url, slug, status, created_at, updated_at
+		SELECT id, name, bio, owner_id, email, phone, logo_url, slug, status, reminder_offsets, timezone, created_at, updated_at
 		FROM businesses
 		WHERE owner_id = $1`
 
@@ -254,6 +321,8 @@ func (b *BusinessModel) GetByOwnerID(ownerID int) (*Business, error) {
 		&business.LogoURL,
 		&business.Slug,
 		&business.Status,
+		pq.Array(&business.ReminderOffsets),
+		&business.Timezone,
 		&business.CreatedAt,
 		&business.UpdatedAt,
 	)
@@ -270,11 +339,16 @@ func (b *BusinessModel) GetByOwnerID(ownerID int) (*Business, error) {
 	return &business, nil
 }
 
+// Update saves the details of a business. The status is left alone, only
+// Suspend and Reinstate change it, so an edit can't undo a suspension that
+// happened in the meantime.
 func (b *BusinessModel) Update(business *Business) error {
 	query := `
 		UPDATE businesses
-		SET name = $1, bio = $2, owner_id = $3, email = $4, phone = $5, logo_url = $6, slug = $7, status = $8
-		WHERE id = $9
+		SET name = $1, bio = $2, owner_id = $3, email = $4, phone = $5, logo_url = $6, slug = $7,
+		reminder_offsets = $8, timezone = $9
+		WHERE id = $10
+		RETURNING status
 	`
 
 	args := []interface{}{
@@ -285,24 +359,22 @@ func (b *BusinessModel) Update(business *Business) error {
 		business.Phone,
 		business.LogoURL,
 		business.Slug,
-		business.Status,
+		pq.Array(business.ReminderOffsets),
+		business.Timezone,
 		business.ID,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := b.DB.ExecContext(ctx, query, args...)
-	if err != nil {
-		return err
-	}
-
-	rowsAffected, err := result.RowsAffected()
+	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&business.Status)
 	if err != nil {
-		return err
-	}
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
 
 	return nil
@@ -337,10 +409,68 @@ func (b *BusinessModel) Delete(id int) error {
 	return nil
 }
 
-func (b *BusinessModel) CanAccessBusinessData(currentUser *User, targetBusinessID int) (bool, error) {
+// IsOpen reports whether the business shows up publicly and takes bookings
+func (b *Business) IsOpen() bool {
+	return b.Status == BusinessStatusActive
+}
+
+// Suspend takes a business off the public listings and stops it from taking
+// bookings, recording why
+func (b *BusinessModel) Suspend(business *Business, reason string) error {
+	query := `
+		UPDATE businesses
+		SET status = 'suspended', suspended_reason = $1, suspended_at = NOW()
+		WHERE id = $2
+		RETURNING status, suspended_reason, suspended_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := b.DB.QueryRowContext(ctx, query, reason, business.ID).Scan(&business.Status, &business.SuspendedReason, &business.SuspendedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reinstate lifts a suspension and makes the business active again
+func (b *BusinessModel) Reinstate(business *Business) error {
+	query := `
+		UPDATE businesses
+		SET status = 'active', suspended_reason = NULL, suspended_at = NULL
+		WHERE id = $1
+		RETURNING status
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := b.DB.QueryRowContext(ctx, query, business.ID).Scan(&business.Status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	business.SuspendedReason = ""
+	business.SuspendedAt = nil
+	return nil
+}
+
+func (b *BusinessModel) CanAccessBusinessData(currentUser *User, permissions Permissions, targetBusinessID int) (bool, error) {
 		
-	// Administrators can access any business data
-	if currentUser.RoleName == "admin" {
+	// businesses:manage grants access to any business data
+	if permissions.Include("businesses:manage") {
 		return true, nil
 	}
 
@@ -0,0 +1,278 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Job is a piece of background work. The payload isn't exposed, it's only
+// meant for the job handler.
+type Job struct {
+	ID          int64      `json:"-"`
+	PublicID    string     `json:"public_id"`
+	Kind        string     `json:"kind"`
+	Payload     []byte     `json:"-"`
+	Status      string     `json:"status"`
+	Progress    int        `json:"progress"`
+	Attempts    int        `json:"attempts"`
+	MaxAttempts int        `json:"max_attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	RunAt       time.Time  `json:"run_at"`
+	LockedUntil *time.Time `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Pending jobs wait for a runner. A runner marks the job it works on as
+// running. Jobs end up completed, or failed once they ran out of attempts.
+const (
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+)
+
+// JobModel is a queue of jobs stored in Postgres
+type JobModel struct {
+	DB *sql.DB
+}
+
+var publicIDRX = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+const jobColumns = `
+		id, public_id, kind, payload, status, progress, attempts, max_attempts,
+		COALESCE(last_error, ''), run_at, locked_until, created_at, updated_at, finished_at`
+
+func scanJob(row scanner, job *Job) error {
+	return row.Scan(
+		&job.ID,
+		&job.PublicID,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Progress,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.RunAt,
+		&job.LockedUntil,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.FinishedAt,
+	)
+}
+
+// Insert adds a job that runs at runAt, or right away when runAt is zero
+func (m *JobModel) Insert(kind string, payload any, runAt time.Time, maxAttempts int) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO jobs (kind, payload, run_at, max_attempts)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + jobColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var job Job
+	err = scanJob(m.DB.QueryRowContext(ctx, query, kind, data, runAt, maxAttempts), &job)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// InsertUnique adds a job unless a job of the same kind is already waiting
+// or running. It returns ErrRecordNotFound when it added nothing. Two
+// concurrent calls can still both add one, so it's meant for jobs that don't
+// mind running twice.
+func (m *JobModel) InsertUnique(kind string, payload any, maxAttempts int) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO jobs (kind, payload, max_attempts)
+		SELECT $1, $2, $3
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM jobs
+			WHERE kind = $1
+			AND status IN ('pending', 'running')
+		)
+		RETURNING ` + jobColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var job Job
+	err = scanJob(m.DB.QueryRowContext(ctx, query, kind, data, maxAttempts), &job)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// Claim hands the next due job of one of the given kinds to a runner. The
+// job is marked as running for the length of the lease. If the runner dies,
+// another one picks the job up once the lease runs out. It returns
+// ErrRecordNotFound when no job is due.
+func (m *JobModel) Claim(kinds []string, lease time.Duration) (*Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := jobQueue.claim(ctx, m.DB, 1, lease, jobColumns, "kind = ANY($3)", pq.Array(kinds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrRecordNotFound
+	}
+
+	var job Job
+	err = scanJob(rows, &job)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, rows.Close()
+}
+
+// SetProgress records how far along a running job is, in percent. It also
+// renews the lease, so long jobs that report progress keep their claim.
+// ErrEditConflict means the lease already ran out.
+func (m *JobModel) SetProgress(job *Job, progress int, lease time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	progress = min(max(progress, 0), 100)
+
+	lockedUntil, err := jobQueue.update(ctx, m.DB, job.ID, job.LockedUntil,
+		`progress = $3, locked_until = NOW() + make_interval(secs => $4)`, progress, lease.Seconds())
+	if err != nil {
+		return err
+	}
+
+	job.Progress = progress
+	job.LockedUntil = lockedUntil
+	return nil
+}
+
+// Complete records that a job finished. ErrEditConflict means the lease ran
+// out and another runner has the job now.
+func (m *JobModel) Complete(job *Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := jobQueue.update(ctx, m.DB, job.ID, job.LockedUntil,
+		`status = 'completed', progress = 100, last_error = NULL, locked_until = NULL, finished_at = NOW()`)
+	if err != nil {
+		return err
+	}
+
+	job.LockedUntil = nil
+	return nil
+}
+
+// Fail records a failed run. The job runs again after retryAfter, or is
+// failed for good once it used up its attempts. ErrEditConflict means the
+// lease ran out and another runner has the job now.
+func (m *JobModel) Fail(job *Job, runErr error, retryAfter time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := jobQueue.update(ctx, m.DB, job.ID, job.LockedUntil,
+		`status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'pending' END,
+		finished_at = CASE WHEN attempts >= max_attempts THEN NOW() END,
+		last_error = $3, locked_until = NULL,
+		run_at = NOW() + make_interval(secs => $4)`, runErr.Error(), retryAfter.Seconds())
+	if err != nil {
+		return err
+	}
+
+	job.LockedUntil = nil
+	return nil
+}
+
+// Release hands a claimed job back without counting the attempt, for
+// runners that stop before they got to it
+func (m *JobModel) Release(job *Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return jobQueue.release(ctx, m.DB, job.ID, job.LockedUntil)
+}
+
+func (m *JobModel) GetByPublicID(publicID string) (*Job, error) {
+	if !publicIDRX.MatchString(publicID) {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + jobColumns + `
+		FROM jobs
+		WHERE public_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var job Job
+	err := scanJob(m.DB.QueryRowContext(ctx, query, publicID), &job)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// DeleteFinished removes completed and failed jobs that finished before
+// olderThan ago
+func (m *JobModel) DeleteFinished(olderThan time.Duration) (int64, error) {
+	query := `
+		DELETE FROM jobs
+		WHERE status IN ('completed', 'failed')
+		AND finished_at < NOW() - make_interval(secs => $1)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,154 @@
+// Filename: internal/data/login_attempts.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Failed sign ins are counted separately for the username that was tried and
+// for the IP address the request came from
+const (
+	LoginKeyUsername = "username"
+	LoginKeyIP       = "ip"
+)
+
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// backoff returns how long to wait after a number of consecutive failures.
+// The wait doubles with every failure, up to maxDelay.
+func backoff(failures int, baseDelay, maxDelay time.Duration) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+	delay := baseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+	return min(delay, maxDelay)
+}
+
+// RetryAfter returns how long the client has to wait before it can try to
+// sign in again as username from ip. Zero means it may try right away.
+func (m *LoginAttemptModel) RetryAfter(username, ip string, baseDelay, maxDelay time.Duration) (time.Duration, error) {
+	query := `
+		SELECT failures, last_failure_at
+		FROM login_attempts
+		WHERE (key_type = 'username' AND key = $1)
+		OR (key_type = 'ip' AND key = $2)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, username, ip)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var wait time.Duration
+
+	for rows.Next() {
+		var failures int
+		var lastFailureAt time.Time
+
+		err := rows.Scan(&failures, &lastFailureAt)
+		if err != nil {
+			return 0, err
+		}
+
+		remaining := time.Until(lastFailureAt.Add(backoff(failures, baseDelay, maxDelay)))
+		wait = max(wait, remaining)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return wait, nil
+}
+
+// RecordFailure counts a failed sign in for both the username and the IP
+// address and returns the number of failures for the username. Counts older
+// than window start again from one.
+func (m *LoginAttemptModel) RecordFailure(username, ip string, window time.Duration) (int, error) {
+	query := `
+		INSERT INTO login_attempts (key_type, key, failures, last_failure_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (key_type, key) DO UPDATE
+		SET failures = CASE
+			WHEN login_attempts.last_failure_at < NOW() - make_interval(secs => $3) THEN 1
+			ELSE login_attempts.failures + 1
+		END,
+		last_failure_at = NOW()
+		RETURNING failures
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	err = tx.QueryRowContext(ctx, query, LoginKeyUsername, username, window.Seconds()).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	var ipFailures int
+	err = tx.QueryRowContext(ctx, query, LoginKeyIP, ip, window.Seconds()).Scan(&ipFailures)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// Reset forgets the failed sign ins for a username. IP addresses are left
+// alone, a successful sign in to one account says nothing about the others
+// tried from the same address.
+func (m *LoginAttemptModel) Reset(username string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE key_type = 'username' AND key = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, username)
+	return err
+}
+
+// DeleteStale removes counts whose last failure was more than olderThan ago
+func (m *LoginAttemptModel) DeleteStale(olderThan time.Duration) (int64, error) {
+	query := `
+		DELETE FROM login_attempts
+		WHERE last_failure_at < NOW() - make_interval(secs => $1)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,273 @@
+// Filename: internal/data/mfa.go
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/totp"
+	"github.com/lib/pq"
+)
+
+// MFA is the TOTP second factor of a user
+type MFA struct {
+	UserID       int        `json:"user_id"`
+	Secret       string     `json:"-"`
+	EnabledAt    *time.Time `json:"enabled_at,omitempty"`
+	LastUsedStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type MFAModel struct {
+	DB *sql.DB
+}
+
+// the number of recovery codes handed out when MFA is turned on
+const recoveryCodeCount = 10
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+	ErrInvalidMFACode    = errors.New("invalid mfa code")
+)
+
+// Enabled reports whether the user finished enrolling
+func (m *MFA) Enabled() bool {
+	return m.EnabledAt != nil
+}
+
+// CanUseMFA reports whether accounts with these permissions can turn on MFA.
+// It is granted to admins and business owners, who manage other people's data.
+func CanUseMFA(permissions Permissions) bool {
+	return permissions.Include("mfa:use")
+}
+
+func (m *MFAModel) GetForUser(userID int) (*MFA, error) {
+	query := `
+		SELECT user_id, secret, enabled_at, last_used_step, created_at
+		FROM user_mfa
+		WHERE user_id = $1`
+
+	var mfa MFA
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.EnabledAt,
+		&mfa.LastUsedStep,
+		&mfa.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &mfa, nil
+}
+
+// Enroll stores a new secret for a user. Starting over is allowed until the
+// enrollment is verified, after that ErrMFAAlreadyEnabled is returned.
+func (m *MFAModel) Enroll(userID int, secret string) (*MFA, error) {
+	query := `
+		INSERT INTO user_mfa (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_mfa.enabled_at IS NULL
+		RETURNING user_id, secret, enabled_at, last_used_step, created_at
+	`
+
+	var mfa MFA
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, secret).Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.EnabledAt,
+		&mfa.LastUsedStep,
+		&mfa.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrMFAAlreadyEnabled
+		default:
+			return nil, err
+		}
+	}
+
+	return &mfa, nil
+}
+
+// CheckCode validates a TOTP code and records its step so the same code
+// can't be replayed. It returns ErrInvalidMFACode for wrong or reused codes.
+func (m *MFAModel) CheckCode(mfa *MFA, code string) error {
+	step, ok := totp.ValidateOnce(mfa.Secret, code, time.Now(), mfa.LastUsedStep)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	query := `
+		UPDATE user_mfa
+		SET last_used_step = $1
+		WHERE user_id = $2 AND last_used_step < $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, step, mfa.UserID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// another request used this code first
+	if rowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+
+	mfa.LastUsedStep = step
+	return nil
+}
+
+// Enable finishes the enrollment and replaces the recovery codes of the
+// user. The plaintext codes are returned once and only their hashes are kept.
+func (m *MFAModel) Enable(mfa *MFA) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `UPDATE user_mfa SET enabled_at = NOW() WHERE user_id = $1 RETURNING enabled_at`, mfa.UserID).Scan(&mfa.EnabledAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_mfa_recovery_codes WHERE user_id = $1`, mfa.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO user_mfa_recovery_codes (user_id, code_hash)
+		SELECT $1, unnest($2::bytea[])
+	`
+	_, err = tx.ExecContext(ctx, query, mfa.UserID, pq.ByteaArray(hashes))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes. It returns
+// ErrInvalidMFACode if the code is wrong or was already used.
+func (m *MFAModel) UseRecoveryCode(userID int, code string) error {
+	query := `
+		UPDATE user_mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// Disable removes the second factor and recovery codes of a user
+func (m *MFAModel) Disable(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_mfa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Recovery codes look like ABCDE-FGHIJ so they are easy to type
+func generateRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 7)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// the dash and case don't matter when a recovery code is typed back in
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
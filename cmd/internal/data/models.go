@@ -9,6 +9,19 @@ type Models struct {
 	Businesses *BusinessModel
 	Tokens *TokenModel
 	Roles *RoleModel
+	Permissions *PermissionModel
+	Appointments *AppointmentModel
+	BusinessHours *BusinessHoursModel
+	TimeOff *TimeOffModel
+	Staff *StaffModel
+	StaffSchedule *StaffScheduleModel
+	StaffTimeOff *StaffTimeOffModel
+	Reviews *ReviewModel
+	MFA *MFAModel
+	LoginAttempts *LoginAttemptModel
+	Outbox *OutboxModel
+	Reminders *ReminderModel
+	Jobs *JobModel
 }
 
 func CreateModels(db *sql.DB) *Models {
@@ -18,5 +31,18 @@ func CreateModels(db *sql.DB) *Models {
 		Businesses: &BusinessModel{DB: db},
 		Tokens: &TokenModel{DB: db},
 		Roles: &RoleModel{DB: db},
+		Permissions: &PermissionModel{DB: db},
+		Appointments: &AppointmentModel{DB: db},
+		BusinessHours: &BusinessHoursModel{DB: db},
+		TimeOff: &TimeOffModel{DB: db},
+		Staff: &StaffModel{DB: db},
+		StaffSchedule: &StaffScheduleModel{DB: db},
+		StaffTimeOff: &StaffTimeOffModel{DB: db},
+		Reviews: &ReviewModel{DB: db},
+		MFA: &MFAModel{DB: db},
+		LoginAttempts: &LoginAttemptModel{DB: db},
+		Outbox: &OutboxModel{DB: db},
+		Reminders: &ReminderModel{DB: db},
+		Jobs: &JobModel{DB: db},
 	}
 }
\ No newline at end of file
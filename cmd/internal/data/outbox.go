@@ -0,0 +1,349 @@
+// Filename: internal/data/outbox.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/mailer"
+)
+
+// OutboxEmail is an email waiting in, or delivered from, the outbox. The
+// template data isn't exposed, it can contain tokens.
+type OutboxEmail struct {
+	ID            int64               `json:"id"`
+	Recipient     string              `json:"recipient"`
+	Template      string              `json:"template"`
+	Data          []byte              `json:"-"`
+	Attachments   []mailer.Attachment `json:"-"`
+	Status        string              `json:"status"`
+	Attempts      int                 `json:"attempts"`
+	LastError     string              `json:"last_error,omitempty"`
+	Driver        string              `json:"driver,omitempty"`
+	NextAttemptAt time.Time           `json:"next_attempt_at"`
+	LockedUntil   *time.Time          `json:"-"`
+	SentAt        *time.Time          `json:"sent_at,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+}
+
+// QueuedEmail is an email to add to the outbox together with another change
+type QueuedEmail struct {
+	Recipient   string
+	Template    string
+	Data        any
+	Attachments []mailer.Attachment
+}
+
+// Queued emails wait for a worker. A worker marks the ones it is delivering
+// as sending, emails that fail too often end up dead until an admin replays
+// them.
+const (
+	OutboxStatusQueued  = "queued"
+	OutboxStatusSending = "sending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusDead    = "dead"
+)
+
+// OutboxModel is a queue of emails stored in Postgres, so emails survive
+// restarts and can be added in the same transaction as the change they are
+// about
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+var ErrEmailNotDead = errors.New("email is not dead")
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func enqueueEmails(ctx context.Context, db execer, emails ...*QueuedEmail) error {
+	query := `
+		INSERT INTO email_outbox (recipient, template, data, attachments)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	for _, email := range emails {
+		payload, err := json.Marshal(email.Data)
+		if err != nil {
+			return err
+		}
+
+		attachments := email.Attachments
+		if attachments == nil {
+			attachments = []mailer.Attachment{}
+		}
+
+		files, err := json.Marshal(attachments)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.ExecContext(ctx, query, email.Recipient, email.Template, payload, files)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Enqueue adds an email to the outbox. It makes the outbox usable as the
+// mailer of the handlers.
+func (m *OutboxModel) Enqueue(recipient, templateFile string, data any, attachments ...mailer.Attachment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return enqueueEmails(ctx, m.DB, &QueuedEmail{Recipient: recipient, Template: templateFile, Data: data, Attachments: attachments})
+}
+
+// Claim hands up to limit emails that are due to a worker. The emails are
+// marked as sending for the length of the lease. If the worker dies, another
+// one picks them up once the lease runs out.
+func (m *OutboxModel) Claim(limit int, lease time.Duration) ([]*OutboxEmail, error) {
+	columns := `id, recipient, template, data, attachments, status, attempts, COALESCE(last_error, ''),
+		COALESCE(driver, ''), next_attempt_at, locked_until, sent_at, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := outboxQueue.claim(ctx, m.DB, limit, lease, columns, "TRUE")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*OutboxEmail{}
+
+	for rows.Next() {
+		var email OutboxEmail
+		var files []byte
+
+		err := rows.Scan(
+			&email.ID,
+			&email.Recipient,
+			&email.Template,
+			&email.Data,
+			&files,
+			&email.Status,
+			&email.Attempts,
+			&email.LastError,
+			&email.Driver,
+			&email.NextAttemptAt,
+			&email.LockedUntil,
+			&email.SentAt,
+			&email.CreatedAt,
+			&email.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(files, &email.Attachments)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, &email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// MarkSent records a delivery. The template data and attachments are dropped
+// since they're no longer needed and can contain tokens. ErrEditConflict
+// means the lease on the email ran out before it was sent.
+func (m *OutboxModel) MarkSent(email *OutboxEmail, driver string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := outboxQueue.update(ctx, m.DB, email.ID, email.LockedUntil,
+		`status = 'sent', driver = $3, data = '{}', attachments = '[]', last_error = NULL,
+		locked_until = NULL, sent_at = NOW()`, driver)
+	if err != nil {
+		return err
+	}
+
+	email.LockedUntil = nil
+	return nil
+}
+
+// MarkFailed records a failed delivery. The email is tried again after
+// retryAfter, or is dead once it has used up maxAttempts. ErrEditConflict
+// means the lease on the email ran out before it was tried.
+func (m *OutboxModel) MarkFailed(email *OutboxEmail, driver string, sendErr error, maxAttempts int, retryAfter time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := outboxQueue.update(ctx, m.DB, email.ID, email.LockedUntil,
+		`status = CASE WHEN attempts >= $3 THEN 'dead' ELSE 'queued' END,
+		driver = $4, last_error = $5, locked_until = NULL,
+		next_attempt_at = NOW() + make_interval(secs => $6)`,
+		maxAttempts, driver, sendErr.Error(), retryAfter.Seconds())
+	if err != nil {
+		return err
+	}
+
+	email.LockedUntil = nil
+	return nil
+}
+
+// Release hands a claimed email back to the queue without counting the
+// attempt, for workers that stop before they got to it
+func (m *OutboxModel) Release(email *OutboxEmail) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return outboxQueue.release(ctx, m.DB, email.ID, email.LockedUntil)
+}
+
+func (m *OutboxModel) Get(id int64) (*OutboxEmail, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, recipient, template, status, attempts, COALESCE(last_error, ''),
+		COALESCE(driver, ''), next_attempt_at, sent_at, created_at, updated_at
+		FROM email_outbox
+		WHERE id = $1`
+
+	var email OutboxEmail
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&email.ID,
+		&email.Recipient,
+		&email.Template,
+		&email.Status,
+		&email.Attempts,
+		&email.LastError,
+		&email.Driver,
+		&email.NextAttemptAt,
+		&email.SentAt,
+		&email.CreatedAt,
+		&email.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &email, nil
+}
+
+// GetAll returns a page of the emails with a status, or of every email when
+// status is empty
+func (m *OutboxModel) GetAll(status string, filters Filters) ([]*OutboxEmail, Metadata, error) {
+	query := `
+		SELECT count(*) OVER() AS total_count,
+		id, recipient, template, status, attempts, COALESCE(last_error, ''),
+		COALESCE(driver, ''), next_attempt_at, sent_at, created_at, updated_at
+		FROM email_outbox
+		WHERE (status = $1 OR $1 = '')
+		ORDER BY ` + filters.sortColumn() + ` ` + filters.sortDirection() + `, id ASC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	emails := []*OutboxEmail{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var email OutboxEmail
+
+		err := rows.Scan(
+			&totalRecords,
+			&email.ID,
+			&email.Recipient,
+			&email.Template,
+			&email.Status,
+			&email.Attempts,
+			&email.LastError,
+			&email.Driver,
+			&email.NextAttemptAt,
+			&email.SentAt,
+			&email.CreatedAt,
+			&email.UpdatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		emails = append(emails, &email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return emails, metadata, nil
+}
+
+// Replay puts a dead email back in the queue with a fresh set of attempts.
+// It returns ErrEmailNotDead for emails that aren't dead.
+func (m *OutboxModel) Replay(email *OutboxEmail) error {
+	query := `
+		UPDATE email_outbox
+		SET status = 'queued', attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'dead'
+		RETURNING status, attempts, next_attempt_at, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email.ID).Scan(&email.Status, &email.Attempts, &email.NextAttemptAt, &email.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEmailNotDead
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteSent removes emails that were delivered more than olderThan ago
+func (m *OutboxModel) DeleteSent(olderThan time.Duration) (int64, error) {
+	query := `
+		DELETE FROM email_outbox
+		WHERE status = 'sent'
+		AND sent_at < NOW() - make_interval(secs => $1)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
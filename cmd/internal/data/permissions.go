@@ -0,0 +1,211 @@
+// Filename: internal/data/permissions.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Permissions holds permission codes like "appointments:write"
+type Permissions []string
+
+// Include reports whether code is one of the permissions
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+// Missing returns the codes that aren't among the permissions
+func (p Permissions) Missing(codes Permissions) Permissions {
+	missing := Permissions{}
+	for _, code := range codes {
+		if !p.Include(code) {
+			missing = append(missing, code)
+		}
+	}
+
+	return missing
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+var ErrUnknownPermission = errors.New("unknown permission")
+
+// GetAll returns the code of every permission that can be granted
+func (m *PermissionModel) GetAll() (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPermissions(rows)
+}
+
+// GetAllForRole returns the permissions granted to a role
+func (m *PermissionModel) GetAllForRole(roleID int) (Permissions, error) {
+	query := `
+		SELECT p.code
+		FROM permissions p
+		JOIN role_permissions rp ON rp.permission_id = p.id
+		WHERE rp.role_id = $1
+		ORDER BY p.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, roleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPermissions(rows)
+}
+
+// GetAllForUser returns the permissions granted to the role of a user and
+// to the user directly
+func (m *PermissionModel) GetAllForUser(userID int) (Permissions, error) {
+	query := `
+		SELECT p.code
+		FROM permissions p
+		JOIN role_permissions rp ON rp.permission_id = p.id
+		JOIN users u ON u.role_id = rp.role_id
+		WHERE u.id = $1
+		UNION
+		SELECT p.code
+		FROM permissions p
+		JOIN user_permissions up ON up.permission_id = p.id
+		WHERE up.user_id = $1
+		ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPermissions(rows)
+}
+
+// AddForUser grants permissions to a single user, on top of those of their
+// role. Codes that don't exist return ErrUnknownPermission and nothing changes.
+func (m *PermissionModel) AddForUser(userID int, codes Permissions) error {
+	codes = slices.Compact(slices.Sorted(slices.Values(codes)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var known int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM permissions WHERE code = ANY($1)`, pq.Array(codes)).Scan(&known)
+	if err != nil {
+		return err
+	}
+	if known != len(codes) {
+		return ErrUnknownPermission
+	}
+
+	query := `
+		INSERT INTO user_permissions (user_id, permission_id)
+		SELECT $1, p.id FROM permissions p WHERE p.code = ANY($2)
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err = tx.ExecContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		if strings.Contains(err.Error(), "violates foreign key constraint") {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceForRole sets the permissions of a role to exactly codes. Codes that
+// don't exist return ErrUnknownPermission and nothing changes.
+func (m *PermissionModel) ReplaceForRole(roleID int, codes Permissions) error {
+	codes = slices.Compact(slices.Sorted(slices.Values(codes)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_id = $1`, roleID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO role_permissions (role_id, permission_id)
+		SELECT $1, p.id FROM permissions p WHERE p.code = ANY($2)
+	`
+
+	result, err := tx.ExecContext(ctx, query, roleID, pq.Array(codes))
+	if err != nil {
+		if strings.Contains(err.Error(), "violates foreign key constraint") {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(rowsAffected) != len(codes) {
+		return ErrUnknownPermission
+	}
+
+	return tx.Commit()
+}
+
+func scanPermissions(rows *sql.Rows) (Permissions, error) {
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var code string
+
+		err := rows.Scan(&code)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, code)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
@@ -0,0 +1,87 @@
+// Filename: internal/data/queue.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// leaseQueue is a table that works as a queue, like the email outbox and the
+// jobs table. A worker claims due rows for the length of a lease by moving
+// them to the claimed status. Rows whose lease ran out are due again, so a
+// worker that dies doesn't lose them. Every change a worker makes to a row
+// it claimed checks that it still holds the lease.
+type leaseQueue struct {
+	table   string
+	waiting string // status of rows waiting for a worker
+	claimed string // status of rows a worker holds
+	dueAt   string // column saying when a waiting row is due
+}
+
+var (
+	outboxQueue = leaseQueue{table: "email_outbox", waiting: OutboxStatusQueued, claimed: OutboxStatusSending, dueAt: "next_attempt_at"}
+	jobQueue    = leaseQueue{table: "jobs", waiting: JobStatusPending, claimed: JobStatusRunning, dueAt: "run_at"}
+)
+
+// claim hands up to limit due rows matching filter to a worker and returns
+// columns of them. filter can use $3 onwards.
+func (q leaseQueue) claim(ctx context.Context, db *sql.DB, limit int, lease time.Duration, columns, filter string, args ...any) (*sql.Rows, error) {
+	query := `
+		UPDATE ` + q.table + `
+		SET status = '` + q.claimed + `', attempts = attempts + 1,
+		locked_until = NOW() + make_interval(secs => $2), updated_at = NOW()
+		WHERE id IN (
+			SELECT id
+			FROM ` + q.table + `
+			WHERE ((status = '` + q.waiting + `' AND ` + q.dueAt + ` <= NOW())
+			OR (status = '` + q.claimed + `' AND locked_until < NOW()))
+			AND ` + filter + `
+			ORDER BY ` + q.dueAt + `, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + columns
+
+	return db.QueryContext(ctx, query, append([]any{limit, lease.Seconds()}, args...)...)
+}
+
+// update changes a claimed row while the worker still holds the lease it got
+// with lockedUntil, and returns the lease set leaves the row with. set can
+// use $3 onwards. ErrEditConflict means the lease ran out and the row was
+// handed to another worker.
+func (q leaseQueue) update(ctx context.Context, db *sql.DB, id int64, lockedUntil *time.Time, set string, args ...any) (*time.Time, error) {
+	query := `
+		UPDATE ` + q.table + `
+		SET ` + set + `, updated_at = NOW()
+		WHERE id = $1 AND status = '` + q.claimed + `' AND locked_until = $2
+		RETURNING locked_until`
+
+	var next *time.Time
+	err := db.QueryRowContext(ctx, query, append([]any{id, lockedUntil}, args...)...).Scan(&next)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	return next, nil
+}
+
+// release hands a claimed row back without counting the attempt, for
+// workers that stop before they got to it
+func (q leaseQueue) release(ctx context.Context, db *sql.DB, id int64, lockedUntil *time.Time) error {
+	_, err := q.update(ctx, db, id, lockedUntil,
+		`status = '`+q.waiting+`', attempts = attempts - 1, locked_until = NULL`)
+	return err
+}
+
+// RetryDelay returns how long to wait before the next attempt at a queued
+// email or job. The wait doubles after every attempt, up to maxDelay.
+func RetryDelay(attempts int, baseDelay, maxDelay time.Duration) time.Duration {
+	return backoff(attempts, baseDelay, maxDelay)
+}
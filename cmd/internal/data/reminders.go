@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DueReminder is a reminder an appointment is due for. OffsetHours is the
+// business's reminder offset that came up.
+type DueReminder struct {
+	Appointment *Appointment
+	OffsetHours int
+}
+
+// ReminderModel keeps track of the reminders sent for appointments in
+// appointment_reminders, so no reminder goes out twice
+type ReminderModel struct {
+	DB *sql.DB
+}
+
+// SendDue finds confirmed appointments that reached one of their business's
+// reminder offsets, logs a reminder for each and queues the email that the
+// email function builds for it. It returns how many reminders were queued.
+//
+// The appointments are locked while this runs and locked ones are skipped,
+// so any number of API instances can call SendDue at the same time. An
+// appointment that is due for several offsets at once, because it was booked
+// or confirmed late, only gets a reminder for the closest one. Offsets
+// further out than a reminder that was already sent are skipped.
+func (m *ReminderModel) SendDue(limit int, email func(reminder *DueReminder) *QueuedEmail) (int, error) {
+	query := `
+		SELECT o.hours, ` + appointmentColumns + appointmentJoins + `
+		CROSS JOIN LATERAL unnest(b.reminder_offsets) AS o(hours)
+		WHERE a.status = 'confirmed'
+		AND b.status = 'active'
+		AND a.start_time > NOW()
+		AND a.start_time <= NOW() + make_interval(hours => o.hours)
+		AND NOT EXISTS (
+			SELECT 1
+			FROM appointment_reminders r
+			WHERE r.appointment_id = a.id
+			AND r.offset_hours <= o.hours
+		)
+		ORDER BY a.start_time, a.id, o.hours
+		LIMIT $1
+		FOR UPDATE OF a SKIP LOCKED`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	// the closest offset of an appointment comes first
+	due := []*DueReminder{}
+	seen := map[int]bool{}
+
+	for rows.Next() {
+		var reminder DueReminder
+		var appointment Appointment
+
+		err := scanAppointment(rows, &appointment, &reminder.OffsetHours)
+		if err != nil {
+			return 0, err
+		}
+
+		if seen[appointment.ID] {
+			continue
+		}
+		seen[appointment.ID] = true
+
+		reminder.Appointment = &appointment
+		due = append(due, &reminder)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	query = `
+		INSERT INTO appointment_reminders (appointment_id, offset_hours)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+
+	sent := 0
+	for _, reminder := range due {
+		result, err := tx.ExecContext(ctx, query, reminder.Appointment.ID, reminder.OffsetHours)
+		if err != nil {
+			return 0, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+
+		// another instance got there first
+		if rowsAffected == 0 {
+			continue
+		}
+
+		err = enqueueEmails(ctx, tx, email(reminder))
+		if err != nil {
+			return 0, err
+		}
+		sent++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return sent, nil
+}
@@ -0,0 +1,315 @@
+// Filename: internal/data/reviews.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// Review is a rating a customer left for a completed appointment
+type Review struct {
+	ID            int         `json:"id"`
+	AppointmentID int         `json:"appointment_id"`
+	BusinessID    int         `json:"business_id"`
+	ServiceName   string      `json:"service_name"`
+	CustomerID    int         `json:"customer_id"`
+	CustomerName  string      `json:"customer_name"`
+	Rating        int         `json:"rating"`
+	Comment       string      `json:"comment,omitempty"`
+	State         ReviewState `json:"state"`
+	Reply         string      `json:"reply,omitempty"`
+	RepliedAt     *time.Time  `json:"replied_at,omitempty"`
+	FlagReason    string      `json:"flag_reason,omitempty"`
+	FlaggedAt     *time.Time  `json:"flagged_at,omitempty"`
+	ModeratedAt   *time.Time  `json:"moderated_at,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+}
+
+// ReviewState tracks moderation. Flagged reviews stay public until an admin
+// looks at them, hidden reviews are only visible to admins.
+type ReviewState string
+
+const (
+	ReviewStateVisible ReviewState = "visible"
+	ReviewStateFlagged ReviewState = "flagged"
+	ReviewStateHidden  ReviewState = "hidden"
+)
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+	ErrDuplicateReply  = errors.New("duplicate reply")
+)
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(len(review.Comment) <= 2000, "comment", "must not be more than 2000 characters long")
+}
+
+const reviewColumns = `
+		r.id,
+		r.appointment_id,
+		r.business_id,
+		s.name AS service_name,
+		a.customer_id,
+		u.username AS customer_name,
+		r.rating,
+		COALESCE(r.comment, ''),
+		r.state,
+		COALESCE(r.reply, ''),
+		r.replied_at,
+		COALESCE(r.flag_reason, ''),
+		r.flagged_at,
+		r.moderated_at,
+		r.created_at`
+
+const reviewJoins = `
+		FROM reviews r
+		JOIN appointments a ON r.appointment_id = a.id
+		JOIN services s ON a.service_id = s.id
+		JOIN users u ON a.customer_id = u.id`
+
+func scanReview(row scanner, review *Review, extra ...any) error {
+	dest := append(extra,
+		&review.ID,
+		&review.AppointmentID,
+		&review.BusinessID,
+		&review.ServiceName,
+		&review.CustomerID,
+		&review.CustomerName,
+		&review.Rating,
+		&review.Comment,
+		&review.State,
+		&review.Reply,
+		&review.RepliedAt,
+		&review.FlagReason,
+		&review.FlaggedAt,
+		&review.ModeratedAt,
+		&review.CreatedAt,
+	)
+	return row.Scan(dest...)
+}
+
+// Insert stores a review of an appointment. An appointment can only be
+// reviewed once, a second review returns ErrDuplicateReview.
+func (r *ReviewModel) Insert(review *Review) (*Review, error) {
+	query := `
+		INSERT INTO reviews (appointment_id, business_id, rating, comment)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, state, created_at
+	`
+
+	args := []interface{}{
+		review.AppointmentID,
+		review.BusinessID,
+		review.Rating,
+		review.Comment,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.State, &review.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") && strings.Contains(err.Error(), "reviews_appointment_id_key") {
+			return nil, ErrDuplicateReview
+		}
+		return nil, err
+	}
+
+	return review, nil
+}
+
+func (r *ReviewModel) Get(id int) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + reviewColumns + reviewJoins + `
+		WHERE r.id = $1`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanReview(r.DB.QueryRowContext(ctx, query, id), &review)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// GetAllForBusiness returns a page of the public reviews of a business,
+// hidden reviews are left out
+func (r *ReviewModel) GetAllForBusiness(businessID int, filters Filters) ([]*Review, Metadata, error) {
+	query := `
+		SELECT count(*) OVER() AS total_count,` + reviewColumns + reviewJoins + `
+		WHERE r.business_id = $1
+		AND r.state <> 'hidden'
+		ORDER BY r.` + filters.sortColumn() + ` ` + filters.sortDirection() + `, r.id ASC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, businessID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	reviews := []*Review{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var review Review
+
+		err := scanReview(rows, &review, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return reviews, metadata, nil
+}
+
+// GetAllByState returns a page of the reviews in a moderation state, or of
+// every review when state is empty
+func (r *ReviewModel) GetAllByState(state ReviewState, filters Filters) ([]*Review, Metadata, error) {
+	query := `
+		SELECT count(*) OVER() AS total_count,` + reviewColumns + reviewJoins + `
+		WHERE (r.state = $1 OR $1 = '')
+		ORDER BY r.` + filters.sortColumn() + ` ` + filters.sortDirection() + `, r.id ASC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, state, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	reviews := []*Review{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var review Review
+
+		err := scanReview(rows, &review, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return reviews, metadata, nil
+}
+
+// SetReply stores the business owner's public reply. A review only gets one
+// reply, a second one returns ErrDuplicateReply.
+func (r *ReviewModel) SetReply(review *Review, reply string) error {
+	query := `
+		UPDATE reviews
+		SET reply = $1, replied_at = NOW()
+		WHERE id = $2 AND reply IS NULL
+		RETURNING reply, replied_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := r.DB.QueryRowContext(ctx, query, reply, review.ID).Scan(&review.Reply, &review.RepliedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateReply
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flag reports a review for moderation. Reviews an admin already hid stay
+// hidden and reviews that were restored can be flagged again.
+func (r *ReviewModel) Flag(review *Review, flaggedBy int, reason string) error {
+	query := `
+		UPDATE reviews
+		SET state = CASE WHEN state = 'hidden' THEN state ELSE 'flagged' END,
+		flag_reason = $1, flagged_by = $2, flagged_at = NOW()
+		WHERE id = $3
+		RETURNING state, flag_reason, flagged_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := r.DB.QueryRowContext(ctx, query, reason, flaggedBy, review.ID).Scan(&review.State, &review.FlagReason, &review.FlaggedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetState records an admin's moderation decision
+func (r *ReviewModel) SetState(review *Review, state ReviewState) error {
+	query := `
+		UPDATE reviews
+		SET state = $1, moderated_at = NOW()
+		WHERE id = $2
+		RETURNING state, moderated_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := r.DB.QueryRowContext(ctx, query, state, review.ID).Scan(&review.State, &review.ModeratedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
@@ -16,12 +17,27 @@ type Role struct {
 	RoleName string `json:"role_name"`
 }
 
+// The roles the application itself relies on. They can't be renamed or
+// deleted, but their permissions can be changed like any other role's.
+const (
+	RoleAdmin    = "admin"
+	RoleBusiness = "business"
+	RoleUser     = "user"
+)
+
+// IsBuiltIn reports whether the role is one of the built-in roles
+func (r *Role) IsBuiltIn() bool {
+	return r.RoleName == RoleAdmin || r.RoleName == RoleBusiness || r.RoleName == RoleUser
+}
+
 // ValidateRole validates a role struct
 func ValidateRole(v *validator.Validator, role *Role) {
 	v.Check(role.RoleName != "", "role_name", "must be provided")
 	v.Check(len(role.RoleName) <= 50, "role_name", "must not be more than 50 characters long")
 }
 
+var ErrDuplicateRole = errors.New("duplicate role")
+
 // RoleModel wraps a database connection pool
 type RoleModel struct {
 	DB *sql.DB
@@ -37,7 +53,15 @@ func (r *RoleModel) Insert(role *Role) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return r.DB.QueryRowContext(ctx, query, role.RoleName).Scan(&role.ID)
+	err := r.DB.QueryRowContext(ctx, query, role.RoleName).Scan(&role.ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") && strings.Contains(err.Error(), "roles_role_key") {
+			return ErrDuplicateRole
+		}
+		return err
+	}
+
+	return nil
 }
 
 // Get retrieves a specific role based on its ID
@@ -163,7 +187,14 @@ func (r *RoleModel) Update(role *Role) error {
 	defer cancel()
 
 	_, err := r.DB.ExecContext(ctx, query, args...)
-	return err
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") && strings.Contains(err.Error(), "roles_role_key") {
+			return ErrDuplicateRole
+		}
+		return err
+	}
+
+	return nil
 }
 
 // Delete removes a role record from the database
@@ -77,6 +77,7 @@ func (s *ServiceModel) GetAll(filters Filters) ([]*Service, Metadata, error) {
 		FROM services s
 		JOIN businesses b 
 			ON s.business_id = b.id
+		WHERE b.status = 'active'
 		ORDER BY s.` + filters.sortColumn() + ` ` + filters.sortDirection() + `
 		LIMIT $1 OFFSET $2`
 
@@ -0,0 +1,383 @@
+// Filename: internal/data/staff.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Staff is a member of a business that appointments can be booked with
+type Staff struct {
+	ID         int        `json:"id"`
+	BusinessID int        `json:"business_id"`
+	Name       string     `json:"name"`
+	Email      string     `json:"email,omitempty"`
+	Phone      string     `json:"phone,omitempty"`
+	Active     bool       `json:"active"`
+	ServiceIDs []int64    `json:"service_ids"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+type StaffModel struct {
+	DB *sql.DB
+}
+
+var ErrServiceNotOffered = errors.New("service is not offered by the business")
+var ErrStaffHasAppointments = errors.New("staff member has upcoming appointments")
+
+func ValidateStaff(v *validator.Validator, staff *Staff) {
+	v.Check(staff.Name != "", "name", "must be provided")
+	v.Check(len(staff.Name) <= 200, "name", "must not be more than 200 characters long")
+
+	if staff.Email != "" {
+		ValidateEmail(v, staff.Email)
+	}
+	v.Check(len(staff.Phone) <= 25, "phone", "must not be more than 25 characters long")
+}
+
+// CanPerform reports whether the staff member is active and offers the service
+func (s *Staff) CanPerform(serviceID int) bool {
+	return s.Active && slices.Contains(s.ServiceIDs, int64(serviceID))
+}
+
+const staffColumns = `
+		st.id,
+		st.business_id,
+		st.name,
+		COALESCE(st.email, ''),
+		COALESCE(st.phone, ''),
+		st.active,
+		ARRAY(SELECT ss.service_id FROM staff_services ss WHERE ss.staff_id = st.id ORDER BY ss.service_id),
+		st.created_at,
+		st.updated_at`
+
+func scanStaff(row scanner, staff *Staff, extra ...any) error {
+	dest := append(extra,
+		&staff.ID,
+		&staff.BusinessID,
+		&staff.Name,
+		&staff.Email,
+		&staff.Phone,
+		&staff.Active,
+		pq.Array(&staff.ServiceIDs),
+		&staff.CreatedAt,
+		&staff.UpdatedAt,
+	)
+	return row.Scan(dest...)
+}
+
+// Insert saves a new staff member along with the services in
+// staff.ServiceIDs. Like SetServices it returns ErrServiceNotOffered if one of
+// them belongs to another business, in which case nothing is saved.
+func (s *StaffModel) Insert(staff *Staff) (*Staff, error) {
+	query := `
+		INSERT INTO business_staff (business_id, name, email, phone, active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	args := []interface{}{
+		staff.BusinessID,
+		staff.Name,
+		staff.Email,
+		staff.Phone,
+		staff.Active,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&staff.ID, &staff.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceIDs, err := setStaffServices(ctx, tx, staff, staff.ServiceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	staff.ServiceIDs = serviceIDs
+	return staff, nil
+}
+
+func (s *StaffModel) Get(id int) (*Staff, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + staffColumns + `
+		FROM business_staff st
+		WHERE st.id = $1`
+
+	var staff Staff
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := scanStaff(s.DB.QueryRowContext(ctx, query, id), &staff)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &staff, nil
+}
+
+// GetAllForBusiness returns a page of the staff of a business
+func (s *StaffModel) GetAllForBusiness(businessID int, filters Filters) ([]*Staff, Metadata, error) {
+	query := `
+		SELECT count(*) OVER() AS total_count,` + staffColumns + `
+		FROM business_staff st
+		WHERE st.business_id = $1
+		ORDER BY st.` + filters.sortColumn() + ` ` + filters.sortDirection() + `, st.id ASC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, businessID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	staffList := []*Staff{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var staff Staff
+
+		err := scanStaff(rows, &staff, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		staffList = append(staffList, &staff)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return staffList, metadata, nil
+}
+
+// GetAllForService returns the active staff members that can perform a service
+func (s *StaffModel) GetAllForService(serviceID int) ([]*Staff, error) {
+	query := `
+		SELECT ` + staffColumns + `
+		FROM business_staff st
+		JOIN staff_services link ON link.staff_id = st.id
+		WHERE link.service_id = $1
+		AND st.active = TRUE
+		ORDER BY st.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	staffList := []*Staff{}
+
+	for rows.Next() {
+		var staff Staff
+
+		err := scanStaff(rows, &staff)
+		if err != nil {
+			return nil, err
+		}
+
+		staffList = append(staffList, &staff)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return staffList, nil
+}
+
+// Update saves the details of a staff member. Whether they are active is
+// left alone, Activate and Deactivate change that.
+func (s *StaffModel) Update(staff *Staff) error {
+	query := `
+		UPDATE business_staff
+		SET name = $1, email = $2, phone = $3
+		WHERE id = $4
+		RETURNING active, updated_at
+	`
+
+	args := []interface{}{
+		staff.Name,
+		staff.Email,
+		staff.Phone,
+		staff.ID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, query, args...).Scan(&staff.Active, &staff.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetServices replaces the services a staff member can perform. Every
+// service has to belong to the staff member's business, otherwise
+// ErrServiceNotOffered is returned and nothing changes.
+func (s *StaffModel) SetServices(staff *Staff, serviceIDs []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	serviceIDs, err = setStaffServices(ctx, tx, staff, serviceIDs)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	staff.ServiceIDs = serviceIDs
+	return nil
+}
+
+// setStaffServices replaces the services of the staff member in tx. It
+// returns the sorted service IDs without duplicates.
+func setStaffServices(ctx context.Context, tx *sql.Tx, staff *Staff, serviceIDs []int64) ([]int64, error) {
+	serviceIDs = slices.Clone(serviceIDs)
+	slices.Sort(serviceIDs)
+	serviceIDs = slices.Compact(serviceIDs)
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM staff_services WHERE staff_id = $1`, staff.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO staff_services (staff_id, service_id)
+		SELECT $1, id
+		FROM services
+		WHERE business_id = $2 AND id = ANY($3)
+	`
+
+	result, err := tx.ExecContext(ctx, query, staff.ID, staff.BusinessID, pq.Array(serviceIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if int(rowsAffected) != len(serviceIDs) {
+		return nil, ErrServiceNotOffered
+	}
+
+	return serviceIDs, nil
+}
+
+// Activate puts a staff member back on the booking calendars
+func (s *StaffModel) Activate(staff *Staff) error {
+	query := `
+		UPDATE business_staff
+		SET active = TRUE
+		WHERE id = $1
+		RETURNING updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, query, staff.ID).Scan(&staff.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	staff.Active = true
+	return nil
+}
+
+// Deactivate takes a staff member off the booking calendars. Staff members
+// are never deleted, their past appointments stay with them. It returns
+// ErrStaffHasAppointments while they still have upcoming appointments.
+func (s *StaffModel) Deactivate(staff *Staff) error {
+	query := `
+		UPDATE business_staff
+		SET active = FALSE
+		WHERE id = $1
+		AND NOT EXISTS (
+			SELECT 1
+			FROM appointments a
+			WHERE a.business_staff_id = $1
+			AND a.status IN ('pending', 'confirmed')
+			AND a.end_time > NOW()
+		)
+		RETURNING updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := s.DB.QueryRowContext(ctx, query, staff.ID).Scan(&staff.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrStaffHasAppointments
+		default:
+			return err
+		}
+	}
+
+	staff.Active = false
+	return nil
+}
@@ -0,0 +1,146 @@
+// Filename: internal/data/staff_schedule.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// StaffHours is one working range of a staff member on a day of the week.
+// day_of_week follows time.Weekday, so 0 is Sunday.
+type StaffHours struct {
+	ID        int       `json:"id"`
+	StaffID   int       `json:"staff_id"`
+	DayOfWeek int       `json:"day_of_week"`
+	StartTime string    `json:"start_time"` // HH:MM
+	EndTime   string    `json:"end_time"`   // HH:MM
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type StaffScheduleModel struct {
+	DB *sql.DB
+}
+
+// ValidateStaffSchedule checks a full weekly schedule of a staff member. On
+// top of the rules for business hours every range has to fall inside the
+// opening hours of the business on that day.
+func ValidateStaffSchedule(v *validator.Validator, schedule []*StaffHours, businessHours []*BusinessHours) {
+	hours := make([]*BusinessHours, len(schedule))
+	for i, s := range schedule {
+		hours[i] = &BusinessHours{DayOfWeek: s.DayOfWeek, StartTime: s.StartTime, EndTime: s.EndTime}
+	}
+
+	ValidateBusinessHours(v, hours)
+	if !v.IsEmpty() {
+		return
+	}
+
+	for i, s := range schedule {
+		start, _ := ParseClock(s.StartTime)
+		end, _ := ParseClock(s.EndTime)
+
+		inside := false
+		for _, bh := range businessHours {
+			open, err := ParseClock(bh.StartTime)
+			if err != nil {
+				continue
+			}
+			closing, err := ParseClock(bh.EndTime)
+			if err != nil {
+				continue
+			}
+			if bh.DayOfWeek == s.DayOfWeek && start >= open && end <= closing {
+				inside = true
+				break
+			}
+		}
+
+		v.Check(inside, fmt.Sprintf("hours[%d]", i), "must be within the business hours of that day")
+	}
+}
+
+// GetAllForStaff returns the weekly schedule of a staff member ordered by day
+// and start time
+func (s *StaffScheduleModel) GetAllForStaff(staffID int) ([]*StaffHours, error) {
+	query := `
+		SELECT id, staff_id, day_of_week,
+		to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'), created_at
+		FROM staff_schedule
+		WHERE staff_id = $1
+		ORDER BY day_of_week, start_time`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, query, staffID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedule := []*StaffHours{}
+
+	for rows.Next() {
+		var h StaffHours
+
+		err := rows.Scan(
+			&h.ID,
+			&h.StaffID,
+			&h.DayOfWeek,
+			&h.StartTime,
+			&h.EndTime,
+			&h.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		schedule = append(schedule, &h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedule, nil
+}
+
+// ReplaceForStaff swaps the whole weekly schedule of a staff member for the
+// given hours in a single transaction
+func (s *StaffScheduleModel) ReplaceForStaff(staffID int, schedule []*StaffHours) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM staff_schedule WHERE staff_id = $1`, staffID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO staff_schedule (staff_id, day_of_week, start_time, end_time)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	for _, h := range schedule {
+		h.StaffID = staffID
+		args := []interface{}{h.StaffID, h.DayOfWeek, h.StartTime, h.EndTime}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&h.ID, &h.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,237 @@
+// Filename: internal/data/staff_time_off.go
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
+)
+
+// StaffTimeOff is a period in which a single staff member is away, e.g. a
+// sick day, while the rest of the business stays open
+type StaffTimeOff struct {
+	ID        int       `json:"id"`
+	StaffID   int       `json:"staff_id"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type StaffTimeOffModel struct {
+	DB *sql.DB
+}
+
+func ValidateStaffTimeOff(v *validator.Validator, timeOff *StaffTimeOff) {
+	v.Check(!timeOff.StartTime.IsZero(), "start_time", "must be provided")
+	v.Check(!timeOff.EndTime.IsZero(), "end_time", "must be provided")
+	v.Check(timeOff.EndTime.After(timeOff.StartTime), "end_time", "must be after start_time")
+	v.Check(len(timeOff.Reason) <= 500, "reason", "must not be more than 500 characters long")
+}
+
+// Insert saves new time off. Appointments that clash with it are cancelled
+// in the same transaction, on behalf of changedBy. If one of them changed
+// since it was read nothing is saved and ErrEditConflict is returned.
+func (t *StaffTimeOffModel) Insert(timeOff *StaffTimeOff, changedBy int, cancellations ...*Cancellation) (*StaffTimeOff, error) {
+	query := `
+		INSERT INTO staff_time_off (staff_id, start_datetime, end_datetime, reason)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	args := []interface{}{
+		timeOff.StaffID,
+		timeOff.StartTime,
+		timeOff.EndTime,
+		timeOff.Reason,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&timeOff.ID, &timeOff.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cancelAppointments(ctx, tx, changedBy, cancellations)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	cancelled(cancellations)
+	return timeOff, nil
+}
+
+func (t *StaffTimeOffModel) Get(id int) (*StaffTimeOff, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, staff_id, start_datetime, end_datetime, COALESCE(reason, ''), created_at
+		FROM staff_time_off
+		WHERE id = $1`
+
+	var timeOff StaffTimeOff
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := t.DB.QueryRowContext(ctx, query, id).Scan(
+		&timeOff.ID,
+		&timeOff.StaffID,
+		&timeOff.StartTime,
+		&timeOff.EndTime,
+		&timeOff.Reason,
+		&timeOff.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &timeOff, nil
+}
+
+// GetAllForStaff returns a page of the time off of a staff member
+func (t *StaffTimeOffModel) GetAllForStaff(staffID int, filters Filters) ([]*StaffTimeOff, Metadata, error) {
+	query := `
+		SELECT count(*) OVER() AS total_count,
+		id, staff_id, start_datetime, end_datetime, COALESCE(reason, ''), created_at
+		FROM staff_time_off
+		WHERE staff_id = $1
+		ORDER BY ` + filters.sortColumn() + ` ` + filters.sortDirection() + `, id ASC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, staffID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	timeOff := []*StaffTimeOff{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var off StaffTimeOff
+
+		err := rows.Scan(
+			&totalRecords,
+			&off.ID,
+			&off.StaffID,
+			&off.StartTime,
+			&off.EndTime,
+			&off.Reason,
+			&off.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		timeOff = append(timeOff, &off)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return timeOff, metadata, nil
+}
+
+// GetAllInRange returns the time off of a staff member that overlaps [from, to)
+func (t *StaffTimeOffModel) GetAllInRange(staffID int, from, to time.Time) ([]*StaffTimeOff, error) {
+	query := `
+		SELECT id, staff_id, start_datetime, end_datetime, COALESCE(reason, ''), created_at
+		FROM staff_time_off
+		WHERE staff_id = $1
+		AND start_datetime < $3
+		AND end_datetime > $2
+		ORDER BY start_datetime`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, staffID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	timeOff := []*StaffTimeOff{}
+
+	for rows.Next() {
+		var off StaffTimeOff
+
+		err := rows.Scan(
+			&off.ID,
+			&off.StaffID,
+			&off.StartTime,
+			&off.EndTime,
+			&off.Reason,
+			&off.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		timeOff = append(timeOff, &off)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return timeOff, nil
+}
+
+func (t *StaffTimeOffModel) Delete(id int) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM staff_time_off
+		WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/Lee26Ed/lockit_appointments/cmd/internal/validator"
@@ -14,6 +15,12 @@ import (
 // Purpose of the token
 const ScopeActivation = "activation"
 const ScopeAuthentication = "authentication"
+const ScopePasswordReset = "password-reset"
+const ScopeRefresh = "refresh"
+const ScopeMFAPending = "mfa-pending"
+const ScopeEmailChange = "email-change"
+
+var ErrRefreshTokenReused = errors.New("refresh token reused")
 
 // Define our token
 type Token struct {
@@ -22,11 +29,26 @@ type Token struct {
     UserID    int       `json:"-"`
     Expiry    time.Time   `json:"expiry"`
     Scope     string      `json:"-"`
+    UserAgent string      `json:"-"`
+    IPAddress string      `json:"-"`
+    FamilyID  string      `json:"-"`
+    PendingEmail string   `json:"-"`
+}
+
+// Session describes a sign in without revealing its tokens, so users can see
+// where they are signed in. The ID is the one of the current refresh token.
+type Session struct {
+    ID        int       `json:"id"`
+    UserAgent string    `json:"user_agent"`
+    IPAddress string    `json:"ip_address"`
+    Current   bool      `json:"current"`
+    CreatedAt time.Time `json:"created_at"`
+    Expiry    time.Time `json:"expiry"`
 }
 
 
-// Generate a token for the user
-func generateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
+// GenerateToken creates a token for the user without storing it
+func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
     token := &Token {
         UserID: userID,
         Expiry: time.Now().Add(ttl),
@@ -49,6 +71,16 @@ func generateToken(userID int, ttl time.Duration, scope string) (*Token, error)
 	return token,  nil
 }
 
+// Generate a random ID shared by every token issued from one sign in
+func generateFamilyID() (string, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
 
 // Validate the token the client sends back to us to be 26 bytes long
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
@@ -63,7 +95,7 @@ type TokenModel struct {
 // The New() method creates and returns a new token. It calls Insert() as a 
 // helper method
 func (t TokenModel) New(userID int, ttl time.Duration, scope string) (*Token, error) {
-	token, err := generateToken(userID, ttl, scope)
+	token, err := GenerateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
@@ -72,19 +104,193 @@ func (t TokenModel) New(userID int, ttl time.Duration, scope string) (*Token, er
 	return token, err
 }
 
-// Do the actual insert in to the database table
-func (t TokenModel) Insert(token *Token) error {
-    query := `
-              INSERT INTO auth_tokens (token, user_id, expires_at, scope) 
-              VALUES ($1, $2, $3, $4)
+// NewSession signs a user in. It creates a short-lived access token and a
+// long-lived refresh token in a new family and records which client they
+// were issued to.
+func (t TokenModel) NewSession(userID int, accessTTL, refreshTTL time.Duration, userAgent, ipAddress string) (*Token, *Token, error) {
+	familyID, err := generateFamilyID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	access, refresh, err := insertTokenPair(ctx, tx, userID, familyID, accessTTL, refreshTTL, userAgent, ipAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// Rotate swaps a refresh token for a new access and refresh token in the same
+// family. Every refresh token works once: presenting one that was already
+// used means it was stolen, so the whole family is revoked and
+// ErrRefreshTokenReused is returned.
+func (t TokenModel) Rotate(refreshPlaintext string, accessTTL, refreshTTL time.Duration, userAgent, ipAddress string) (*Token, *Token, error) {
+	tokenHash := sha256.Sum256([]byte(refreshPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+            SELECT token_id, user_id, family_id, used_at
+            FROM auth_tokens
+            WHERE token = $1 AND scope = $2 AND expires_at > NOW()
+            FOR UPDATE
+			`
+
+	var (
+		tokenID  int
+		userID   int
+		familyID string
+		usedAt   *time.Time
+	)
+
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(&tokenID, &userID, &familyID, &usedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if usedAt != nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM auth_tokens WHERE family_id = $1`, familyID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	// used refresh tokens are kept until they expire so reuse can be spotted
+	_, err = tx.ExecContext(ctx, `UPDATE auth_tokens SET used_at = NOW() WHERE token_id = $1`, tokenID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the access tokens issued before are replaced by the new one
+	_, err = tx.ExecContext(ctx, `DELETE FROM auth_tokens WHERE family_id = $1 AND scope = $2`, familyID, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := insertTokenPair(ctx, tx, userID, familyID, accessTTL, refreshTTL, userAgent, ipAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, tx.Commit()
+}
+
+// insertTokenPair creates an access and a refresh token in a family
+func insertTokenPair(ctx context.Context, tx *sql.Tx, userID int, familyID string, accessTTL, refreshTTL time.Duration, userAgent, ipAddress string) (*Token, *Token, error) {
+	access, err := GenerateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := GenerateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := `
+              INSERT INTO auth_tokens (token, user_id, expires_at, scope, user_agent, ip_address, family_id) 
+              VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)
             `
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
-	
+
+	for _, token := range []*Token{access, refresh} {
+		token.UserAgent = userAgent
+		token.IPAddress = ipAddress
+		token.FamilyID = familyID
+
+		args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IPAddress, token.FamilyID}
+		_, err = tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, nil
+}
+
+// Do the actual insert in to the database table. Emails that carry the
+// token are queued in the same transaction.
+func (t TokenModel) Insert(token *Token, emails ...*QueuedEmail) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := t.DB.ExecContext(ctx, query, args...)
-	return err
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertToken(ctx, tx, token, emails...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Replace inserts the token like Insert, the earlier tokens of the user in
+// the same scope stop working
+func (t TokenModel) Replace(token *Token, emails ...*QueuedEmail) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := t.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM auth_tokens WHERE scope = $1 AND user_id = $2`, token.Scope, token.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = insertToken(ctx, tx, token, emails...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func insertToken(ctx context.Context, db execer, token *Token, emails ...*QueuedEmail) error {
+    query := `
+              INSERT INTO auth_tokens (token, user_id, expires_at, scope, user_agent, ip_address, family_id, pending_email) 
+              VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''))
+            `
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IPAddress, token.FamilyID, token.PendingEmail}
+
+	_, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	return enqueueEmails(ctx, db, emails...)
 }
 
 // Delete a token based on the type and the user
@@ -99,3 +305,132 @@ func (t TokenModel) DeleteAllForUser(scope string, userID int) error {
     _, err := t.DB.ExecContext(ctx, query, scope, userID)
     return err
 }
+
+// DeleteExpired removes the tokens that can no longer be used and returns
+// how many there were
+func (t TokenModel) DeleteExpired() (int64, error) {
+	query := `
+            DELETE FROM auth_tokens
+            WHERE expires_at < NOW()
+			`
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    defer cancel()
+
+    result, err := t.DB.ExecContext(ctx, query)
+    if err != nil {
+        return 0, err
+    }
+
+    return result.RowsAffected()
+}
+
+// DeleteAllSessionsForUser signs a user out everywhere by deleting their
+// access and refresh tokens
+func (t TokenModel) DeleteAllSessionsForUser(userID int) error {
+	query := `
+            DELETE FROM auth_tokens
+            WHERE scope IN ($1, $2) AND user_id = $3
+			`
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    defer cancel()
+
+    _, err := t.DB.ExecContext(ctx, query, ScopeAuthentication, ScopeRefresh, userID)
+    return err
+}
+
+// DeleteSessionByAccessToken signs out the sign in an access token belongs
+// to, deleting the access token and every token of its family
+func (t TokenModel) DeleteSessionByAccessToken(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+            DELETE FROM auth_tokens
+            WHERE token = $1
+            OR family_id = (
+                SELECT family_id FROM auth_tokens WHERE token = $1 AND scope = $2
+            )
+			`
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    defer cancel()
+
+    _, err := t.DB.ExecContext(ctx, query, tokenHash[:], ScopeAuthentication)
+    return err
+}
+
+// GetSessionsForUser lists the active sign ins of a user, newest first, using
+// their unused refresh tokens. currentPlaintext is the access token of the
+// caller and marks their own session.
+func (t TokenModel) GetSessionsForUser(userID int, currentPlaintext string) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentPlaintext))
+
+	query := `
+            SELECT token_id, COALESCE(user_agent, ''), COALESCE(ip_address, ''),
+                   COALESCE(family_id = (
+                       SELECT family_id FROM auth_tokens WHERE token = $3
+                   ), FALSE),
+                   created_at, expires_at
+            FROM auth_tokens
+            WHERE user_id = $1 AND scope = $2 AND used_at IS NULL AND expires_at > NOW()
+            ORDER BY created_at DESC, token_id DESC
+			`
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, userID, ScopeRefresh, currentHash[:])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserAgent,
+			&session.IPAddress,
+			&session.Current,
+			&session.CreatedAt,
+			&session.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSessionForUser revokes one sign in of a user, given the ID of its
+// refresh token, along with every token of its family
+func (t TokenModel) DeleteSessionForUser(tokenID, userID int) error {
+	query := `
+            DELETE FROM auth_tokens
+            WHERE family_id = (
+                SELECT family_id FROM auth_tokens
+                WHERE token_id = $1 AND user_id = $2 AND scope = $3
+            )
+			`
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, tokenID, userID, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
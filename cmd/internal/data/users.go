@@ -23,6 +23,9 @@ type User struct {
 	RoleID       int        `json:"role_id"`
 	RoleName     string     `json:"role_name,omitempty"`
 	LastLogin    *time.Time `json:"last_login,omitempty"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+	SuspendedReason string  `json:"suspended_reason,omitempty"`
+	SuspendedAt  *time.Time `json:"suspended_at,omitempty"`
 	CreatedAt    time.Time  `json:"created_at"`
 	UpdatedAt    *time.Time `json:"updated_at,omitempty"`
 }
@@ -150,7 +153,8 @@ func (u *UserModel) Get(id int) (*User, error) {
 	}
 
 	query := `
-		SELECT id, username, email, password_hash, status, is_activated, last_login, created_at, updated_at, role_id
+		SELECT id, username, email, password_hash, status, is_activated, last_login, created_at, updated_at, role_id, locked_until,
+		COALESCE(suspended_reason, ''), suspended_at
 		FROM users
 		WHERE id = $1`
 
@@ -170,6 +174,9 @@ func (u *UserModel) Get(id int) (*User, error) {
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.RoleID,
+		&user.LockedUntil,
+		&user.SuspendedReason,
+		&user.SuspendedAt,
 	)
 
 	if err != nil {
@@ -247,7 +254,7 @@ func (u *UserModel) GetAll(filters Filters) ([]*User, Metadata, error) {
 // Get a user from the database based on their username provided
 func (u *UserModel) GetByUsername(username string) (*User, error) {
 	query := `
-		SELECT id, username, email, password_hash, role_id, status, is_activated, last_login, created_at, updated_at
+		SELECT id, username, email, password_hash, role_id, status, is_activated, last_login, locked_until, created_at, updated_at
 		FROM users
 		WHERE username = $1
 	`
@@ -266,6 +273,45 @@ func (u *UserModel) GetByUsername(username string) (*User, error) {
 		&user.Status,
 		&user.IsActivated,
 		&user.LastLogin,
+		&user.LockedUntil,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Get a user from the database based on their email address
+func (u *UserModel) GetByEmail(email string) (*User, error) {
+	query := `
+		SELECT id, username, email, password_hash, role_id, status, is_activated, last_login, locked_until, created_at, updated_at
+		FROM users
+		WHERE email = $1
+	`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := u.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Password.hash,
+		&user.RoleID,
+		&user.Status,
+		&user.IsActivated,
+		&user.LastLogin,
+		&user.LockedUntil,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -287,7 +333,7 @@ func (u *UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error
 	query := `
         SELECT users.id, users.created_at, users.username,
                users.email, users.status, users.is_activated,
-               users.role_id, roles.role
+               users.role_id, roles.role, users.locked_until
         FROM users
         INNER JOIN auth_tokens as tokens
         ON users.id = tokens.user_id
@@ -310,6 +356,7 @@ func (u *UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error
 		&user.IsActivated,
 		&user.RoleID,
 		&user.RoleName,
+		&user.LockedUntil,
 	)
 	if err != nil {
 		switch {
@@ -324,10 +371,10 @@ func (u *UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error
 	return &user, nil
 }
 
-func (u *UserModel) CanAccessUserData(currentUser *User, targetUserID int) (bool, error) {
+func (u *UserModel) CanAccessUserData(currentUser *User, permissions Permissions, targetUserID int) (bool, error) {
 		
-	// Administrators can access any user
-	if currentUser.RoleName == "admin" {
+	// users:manage grants access to any user
+	if permissions.Include("users:manage") {
 		return true, nil
 	}
 	
@@ -338,12 +385,13 @@ func (u *UserModel) CanAccessUserData(currentUser *User, targetUserID int) (bool
 
 var ErrEditConflict = errors.New("edit conflict")
 
-// updates a user record in the database
+// updates the profile of a user in the database. Role and status have
+// their own methods so they can't change by accident.
 func (m *UserModel) Update(user *User) (*User, error) {
 	query := `
 		UPDATE users
-		SET username = $1, email = $2, password_hash = $3, role_id = $4
-		WHERE id = $5
+		SET username = $1, email = $2, password_hash = $3
+		WHERE id = $4
 		RETURNING id, username, email, updated_at
 	`
 
@@ -351,7 +399,6 @@ func (m *UserModel) Update(user *User) (*User, error) {
 		user.Username,
 		user.Email,
 		user.Password.hash,
-		user.RoleID,
 		user.ID,
 	}
 
@@ -380,6 +427,78 @@ func (m *UserModel) Update(user *User) (*User, error) {
 	return user, nil
 }
 
+// ConfirmEmailChange switches the email of a user to the address stored with
+// an email-change token and uses up the token. It returns the ID of the user.
+func (m *UserModel) ConfirmEmailChange(tokenPlaintext string) (int, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET email = tokens.pending_email
+		FROM auth_tokens AS tokens
+		WHERE tokens.user_id = users.id
+		AND tokens.token = $1
+		AND tokens.scope = $2
+		AND tokens.expires_at > $3
+		AND tokens.pending_email IS NOT NULL
+		RETURNING users.id
+	`
+
+	var userID int
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], ScopeEmailChange, time.Now()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		case strings.Contains(err.Error(), "duplicate key value violates unique constraint") && strings.Contains(err.Error(), "users_email_key"):
+			return 0, ErrDuplicateEmail
+		default:
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM auth_tokens WHERE scope = $1 AND user_id = $2`, ScopeEmailChange, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// UpdateRole moves a user to another role
+func (m *UserModel) UpdateRole(user *User, roleID int) error {
+	query := `
+		UPDATE users
+		SET role_id = $1
+		WHERE id = $2
+		RETURNING role_id, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, roleID, user.ID).Scan(&user.RoleID, &user.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
 // UpdateActivation updates only the is_active field for a user
 // This is used when activating a user account via email token
 func (m *UserModel) UpdateActivation(userID int, status Status, isActivated bool) error {
@@ -407,6 +526,136 @@ func (m *UserModel) UpdateActivation(userID int, status Status, isActivated bool
 	return nil
 }
 
+// UpdatePassword stores the new password hash of a user
+// This is used when a user resets a forgotten password
+func (m *UserModel) UpdatePassword(user *User) error {
+	query := `
+		UPDATE users
+		SET password_hash = $1
+		WHERE id = $2
+		RETURNING updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.Password.hash, user.ID).Scan(&user.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsLocked reports whether sign in is blocked after too many failed attempts
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
+}
+
+// SetLockedUntil blocks sign in for a user until the given time. A nil
+// time unlocks the account. Emails about the lock are queued in the same
+// transaction.
+func (m *UserModel) SetLockedUntil(userID int, lockedUntil *time.Time, emails ...*QueuedEmail) error {
+	query := `
+		UPDATE users
+		SET locked_until = $1
+		WHERE id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, query, lockedUntil, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = enqueueEmails(ctx, tx, emails...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsSuspended reports whether an admin suspended the account
+func (u *User) IsSuspended() bool {
+	return u.Status == UserStatusSuspended
+}
+
+// Suspend blocks a user from using the API and records why
+func (m *UserModel) Suspend(user *User, reason string) error {
+	query := `
+		UPDATE users
+		SET status = 'suspended', suspended_reason = $1, suspended_at = NOW()
+		WHERE id = $2
+		RETURNING status, suspended_reason, suspended_at
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, reason, user.ID).Scan(&user.Status, &user.SuspendedReason, &user.SuspendedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reinstate lifts a suspension. The user goes back to pending if they never
+// activated their account.
+func (m *UserModel) Reinstate(user *User) error {
+	query := `
+		UPDATE users
+		SET status = CASE WHEN is_activated THEN 'active'::user_status ELSE 'pending'::user_status END,
+		suspended_reason = NULL, suspended_at = NULL
+		WHERE id = $1
+		RETURNING status
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.ID).Scan(&user.Status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	user.SuspendedReason = ""
+	user.SuspendedAt = nil
+	return nil
+}
+
 // Delete removes a user record from the database
 func (u *UserModel) Delete(id int) error {
 	if id < 1 {
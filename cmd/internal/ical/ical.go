@@ -0,0 +1,136 @@
+// Package ical writes calendar invites in the iCalendar format (RFC 5545)
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ContentType is the MIME type of an invite. The method has to be added as
+// a parameter, see Event.ContentType.
+const ContentType = "text/calendar"
+
+// Methods tell the calendar what to do with the event. A request adds or
+// updates it, a cancel removes it.
+const (
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+)
+
+// The status of an event
+const (
+	StatusTentative = "TENTATIVE"
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+const (
+	productID  = "-//Lockit Appointments//Appointments//EN"
+	timeLayout = "20060102T150405Z"
+	// lines longer than this many octets are folded
+	lineLength = 75
+)
+
+// Event is a single event in an invite. Calendars match invites to the
+// event they already have by UID, and only apply an invite with a higher
+// Sequence than the last one they saw.
+type Event struct {
+	UID           string
+	Sequence      int
+	Method        string
+	Status        string
+	Start         time.Time
+	End           time.Time
+	Summary       string
+	Description   string
+	OrganizerName string
+	OrganizerMail string
+	AttendeeName  string
+	AttendeeMail  string
+}
+
+// ContentType returns the MIME type with the method parameter that mail
+// clients look for
+func (e Event) ContentType() string {
+	return fmt.Sprintf("%s; charset=utf-8; method=%s", ContentType, e.Method)
+}
+
+// Marshal writes the invite with the event in it
+func (e Event) Marshal() []byte {
+	var buf bytes.Buffer
+
+	line := func(s string) {
+		buf.WriteString(fold(s))
+		buf.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:" + productID)
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:" + e.Method)
+	line("BEGIN:VEVENT")
+	line("UID:" + e.UID)
+	line(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+	line("DTSTAMP:" + time.Now().UTC().Format(timeLayout))
+	line("DTSTART:" + e.Start.UTC().Format(timeLayout))
+	line("DTEND:" + e.End.UTC().Format(timeLayout))
+	line("SUMMARY:" + escapeText(e.Summary))
+	if e.Description != "" {
+		line("DESCRIPTION:" + escapeText(e.Description))
+	}
+	line("STATUS:" + e.Status)
+	if e.OrganizerMail != "" {
+		line(fmt.Sprintf("ORGANIZER;CN=%s:mailto:%s", quoteParam(e.OrganizerName), e.OrganizerMail))
+	}
+	if e.AttendeeMail != "" {
+		line(fmt.Sprintf("ATTENDEE;CN=%s;ROLE=REQ-PARTICIPANT;RSVP=FALSE:mailto:%s", quoteParam(e.AttendeeName), e.AttendeeMail))
+	}
+	line("END:VEVENT")
+	line("END:VCALENDAR")
+
+	return buf.Bytes()
+}
+
+// escapeText escapes the characters that have a meaning in TEXT values
+func escapeText(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	)
+	return r.Replace(s)
+}
+
+// quoteParam quotes a parameter value. Quoted values can't contain quotes
+// themselves, so those are dropped.
+func quoteParam(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+}
+
+// fold splits a line into lines of at most 75 octets. Continuation lines
+// start with a space. Multi-byte characters are never split.
+func fold(s string) string {
+	if len(s) <= lineLength {
+		return s
+	}
+
+	var b strings.Builder
+	width := 0
+	for _, r := range s {
+		size := len(string(r))
+		if width+size > lineLength {
+			b.WriteString("\r\n ")
+			// the leading space counts towards the length
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+
+	return b.String()
+}
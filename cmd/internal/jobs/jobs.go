@@ -0,0 +1,186 @@
+// Package jobs runs the background work queued in the jobs table
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/workers"
+)
+
+// lease is how long a claimed job stays with its runner. Jobs that take
+// longer have to report progress, which renews the lease.
+const lease = 5 * time.Minute
+
+// defaultMaxAttempts is how often a job runs before it is failed for good
+const defaultMaxAttempts = 5
+
+// Config controls how many workers run and how they retry
+type Config struct {
+	Workers      int
+	PollInterval time.Duration
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+}
+
+// Job is the job a handler is running
+type Job struct {
+	*data.Job
+	jobs *data.JobModel
+}
+
+// Progress records how far along the job is, in percent
+func (j *Job) Progress(percent int) error {
+	return j.jobs.SetProgress(j.Job, percent, lease)
+}
+
+// HandlerFunc runs a job. Returning an error runs the job again later, until
+// it runs out of attempts. ctx is cancelled when the server shuts down.
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+// Runner claims jobs from the jobs table and hands them to the handler
+// registered for their kind. Any number of runners can share the table.
+type Runner struct {
+	jobs     *data.JobModel
+	logger   *slog.Logger
+	config   Config
+	handlers map[string]HandlerFunc
+}
+
+func New(jobs *data.JobModel, logger *slog.Logger, config Config) *Runner {
+	return &Runner{
+		jobs:     jobs,
+		logger:   logger,
+		config:   config,
+		handlers: map[string]HandlerFunc{},
+	}
+}
+
+// Handle registers the handler for a kind of job. Register every handler
+// before calling Run.
+func (r *Runner) Handle(kind string, handler HandlerFunc) {
+	r.handlers[kind] = handler
+}
+
+// Register registers a handler that gets the payload of the job decoded
+// into a T
+func Register[T any](r *Runner, kind string, handler func(ctx context.Context, job *Job, payload T) error) {
+	r.Handle(kind, func(ctx context.Context, job *Job) error {
+		var payload T
+		err := json.Unmarshal(job.Payload, &payload)
+		if err != nil {
+			return fmt.Errorf("decoding payload: %w", err)
+		}
+
+		return handler(ctx, job, payload)
+	})
+}
+
+// Enqueue adds a job that runs as soon as a worker is free
+func (r *Runner) Enqueue(kind string, payload any) (*data.Job, error) {
+	return r.jobs.Insert(kind, payload, time.Time{}, defaultMaxAttempts)
+}
+
+// Every adds a job of the given kind every interval, unless one is still
+// waiting or running. It blocks until ctx is cancelled.
+func (r *Runner) Every(ctx context.Context, kind string, payload any, interval time.Duration) {
+	for {
+		_, err := r.jobs.InsertUnique(kind, payload, defaultMaxAttempts)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			r.logger.Error("scheduling job", "kind", kind, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Run starts the workers and blocks until ctx is cancelled and every worker
+// finished the job it was running
+func (r *Runner) Run(ctx context.Context) {
+	kinds := slices.Collect(maps.Keys(r.handlers))
+
+	// keep going while there is work, otherwise wait for more
+	workers.Run(ctx, r.config.Workers, r.config.PollInterval, func(ctx context.Context) bool {
+		return r.runNext(ctx, kinds)
+	})
+}
+
+// runNext runs one job and reports whether there was one to run
+func (r *Runner) runNext(ctx context.Context, kinds []string) bool {
+	claimed, err := r.jobs.Claim(kinds, lease)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			r.logger.Error("claiming job", "error", err)
+		}
+		return false
+	}
+
+	job := &Job{Job: claimed, jobs: r.jobs}
+	logger := r.logger.With("job", job.PublicID, "kind", job.Kind, "attempt", job.Attempts)
+
+	runErr := r.run(ctx, job)
+	if runErr == nil {
+		err := r.jobs.Complete(job.Job)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			logger.Warn("lease ran out before the job finished, another worker has it")
+		case err != nil:
+			logger.Error("completing job", "error", err)
+		}
+		return true
+	}
+
+	// a job cut short by a shutdown didn't really fail
+	if ctx.Err() != nil {
+		err := r.jobs.Release(job.Job)
+		if err != nil {
+			logger.Error("releasing job", "error", err)
+		}
+		return true
+	}
+
+	retryAfter := data.RetryDelay(job.Attempts, r.config.BaseDelay, r.config.MaxDelay)
+	err = r.jobs.Fail(job.Job, runErr, retryAfter)
+	if err != nil {
+		if errors.Is(err, data.ErrEditConflict) {
+			logger.Warn("lease ran out before the job failed, another worker has it", "error", runErr)
+			return true
+		}
+		logger.Error("failing job", "error", err)
+		return true
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		logger.Error("job failed", "error", runErr)
+		return true
+	}
+	logger.Warn("job failed, retrying", "retry_after", retryAfter, "error", runErr)
+	return true
+}
+
+// run calls the handler of the job and turns a panic into an error
+func (r *Runner) run(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	handler, ok := r.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for job kind %q", job.Kind)
+	}
+
+	return handler(ctx, job)
+}
@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// FileMailer writes every email to a .eml file in a directory instead of
+// sending it, so development doesn't need SMTP credentials. Mail clients
+// open .eml files directly.
+type FileMailer struct {
+	dir    string
+	sender string
+	count  atomic.Int64
+}
+
+// NewFile creates the directory if it doesn't exist yet
+func NewFile(dir, sender string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{dir: dir, sender: sender}, nil
+}
+
+func (m *FileMailer) Send(recipient, templateFile string, data any, attachments ...Attachment) error {
+	email, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+	email.Attachments = attachments
+
+	// the time and a counter keep the files in order and the names unique
+	name := fmt.Sprintf("%s-%04d-%s-%s.eml",
+		time.Now().UTC().Format("20060102T150405"),
+		m.count.Add(1),
+		strings.TrimSuffix(templateFile, ".tmpl"),
+		safeFilename(recipient),
+	)
+
+	file, err := os.Create(filepath.Join(m.dir, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = email.message().WriteTo(file)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// safeFilename keeps letters, digits, dots and dashes of an email address
+func safeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -3,8 +3,9 @@ package mailer
 import (
 	"bytes"
 	"embed"
+	"fmt"
 	"html/template"
-	"time"
+	"io"
 
 	"github.com/go-mail/mail/v2"
 )
@@ -13,75 +14,112 @@ import (
 //go:embed "templates"
 var templateFS embed.FS     // embed the files from templates into our program
 
-// dialer is a connection to the SMTP server
-// sender is who is sending the email to the new user
-type Mailer struct {
-    dialer  *mail.Dialer
-    sender  string
+// Mailer sends the emails built from our templates. Which driver does the
+// actual delivery is picked with the -mail-driver flag.
+type Mailer interface {
+	// Send the email to the user. The data parameter is for the dynamic
+	// data to inject into the template
+	Send(recipient, templateFile string, data any, attachments ...Attachment) error
 }
-// Configure a SMTP connection instance using our credentials from Mailtrap
-func New(host string, port int, username, password, sender string) Mailer {
-    dialer := mail.NewDialer(host, port, username, password)
-    dialer.Timeout = 5 * time.Second
-
-	return Mailer{
-        dialer: dialer,
-        sender: sender,
-    }
+
+// Attachment is a file sent along with an email
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content"`
+}
+
+// The available drivers
+const (
+	DriverSMTP   = "smtp"
+	DriverFile   = "file"
+	DriverMemory = "memory"
+)
+
+// Email is a message rendered from a template, ready to be delivered
+type Email struct {
+	To        string
+	From      string
+	Subject   string
+	PlainBody   string
+	HTMLBody    string
+	Attachments []Attachment
 }
 
-// Send the email to the user. The data parameter is for the dynamic data
-// to inject into the template
-func (m Mailer) Send(recipient, templateFile string, data any) error {
+// render fills in the subject, plainBody and htmlBody parts of a template
+func render(sender, recipient, templateFile string, data any) (*Email, error) {
     tmpl, err := template.New("email").ParseFS(templateFS,
                                                "templates/"+templateFile)
-    
+
     if err != nil {
-        return err
+        return nil, err
     }
 
 	// fill in the subject part
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-	return err
+	return nil, err
 	}
 
 	// fill in the plainBody part
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-	return err
+	return nil, err
 	}
 
 	// fill in the htmlBody part
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-	return err
+	return nil, err
 	}
 
-	// Craft the message from the parts above
+	return &Email{
+		To:        recipient,
+		From:      sender,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
+
+// message crafts the MIME message from the parts of the email
+func (e *Email) message() *mail.Message {
 	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	// send the message. Try to do this at most 3 times before giving up
-	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
-		// If everything worked, return nil.
-		if err == nil {
-		return nil
-		}
-
-		// If it didn't work, sleep for a short time and retry.
-		// We can increase this sleep time if the sending is done in the background
-		time.Sleep(500 * time.Millisecond)
+	msg.SetHeader("To", e.To)
+	msg.SetHeader("From", e.From)
+	msg.SetHeader("Subject", e.Subject)
+	msg.SetBody("text/plain", e.PlainBody)
+	msg.AddAlternative("text/html", e.HTMLBody)
+
+	for _, attachment := range e.Attachments {
+		content := attachment.Content
+		msg.Attach(attachment.Filename,
+			mail.SetHeader(map[string][]string{
+				"Content-Type": {attachment.ContentType + `; name="` + attachment.Filename + `"`},
+			}),
+			mail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			}),
+		)
 	}
 
-		return err            // give up
+	return msg
 }
 
+// New returns the mailer for a driver. dir is only used by the file driver.
+func New(driver, dir, host string, port int, username, password, sender string) (Mailer, error) {
+	switch driver {
+	case DriverSMTP:
+		return NewSMTP(host, port, username, password, sender), nil
+	case DriverFile:
+		return NewFile(dir, sender)
+	case DriverMemory:
+		return NewMemory(sender), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", driver)
+	}
+}
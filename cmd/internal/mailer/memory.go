@@ -0,0 +1,39 @@
+package mailer
+
+import "sync"
+
+// MemoryMailer keeps the emails it is given instead of sending them. It is
+// meant for test runs that want to look at what would have been sent.
+type MemoryMailer struct {
+	sender string
+	mu     sync.Mutex
+	sent   []*Email
+}
+
+func NewMemory(sender string) *MemoryMailer {
+	return &MemoryMailer{sender: sender}
+}
+
+func (m *MemoryMailer) Send(recipient, templateFile string, data any, attachments ...Attachment) error {
+	email, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+	email.Attachments = attachments
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+// Sent returns the emails captured so far, oldest first
+func (m *MemoryMailer) Sent() []*Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sent := make([]*Email, len(m.sent))
+	copy(sent, m.sent)
+	return sent
+}
@@ -0,0 +1,21 @@
+package mailer
+
+// Queue stores emails until a worker delivers them
+type Queue interface {
+	Enqueue(recipient, templateFile string, data any, attachments ...Attachment) error
+}
+
+// QueueMailer puts emails in a queue instead of delivering them, so a slow
+// or broken mail server never holds up a request and no email is lost on a
+// restart
+type QueueMailer struct {
+	queue Queue
+}
+
+func NewQueue(queue Queue) *QueueMailer {
+	return &QueueMailer{queue: queue}
+}
+
+func (m *QueueMailer) Send(recipient, templateFile string, data any, attachments ...Attachment) error {
+	return m.queue.Enqueue(recipient, templateFile, data, attachments...)
+}
@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// SMTPMailer delivers emails through an SMTP server
+type SMTPMailer struct {
+	dialer *mail.Dialer
+	sender string
+}
+
+// NewSMTP configures a SMTP connection instance using our credentials
+func NewSMTP(host string, port int, username, password, sender string) *SMTPMailer {
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	return &SMTPMailer{
+		dialer: dialer,
+		sender: sender,
+	}
+}
+
+func (m *SMTPMailer) Send(recipient, templateFile string, data any, attachments ...Attachment) error {
+	email, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+	email.Attachments = attachments
+
+	// a single attempt, the mail queue retries failed sends with a back-off
+	return m.dialer.DialAndSend(email.message())
+}
@@ -0,0 +1,131 @@
+// Package mailqueue delivers the emails waiting in the outbox
+package mailqueue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/mailer"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/workers"
+)
+
+// batchSize is how many emails a worker claims at once. lease is how long
+// the claimed emails stay with that worker, it's well above the time a
+// batch takes so emails are only picked up again when a worker died.
+const (
+	batchSize = 10
+	lease     = 5 * time.Minute
+)
+
+// Config controls how many workers run and how they retry
+type Config struct {
+	Workers      int
+	PollInterval time.Duration
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+}
+
+// Worker hands the emails in the outbox to the mail driver. Failed emails are
+// retried with an exponential back-off until they run out of attempts.
+type Worker struct {
+	outbox    *data.OutboxModel
+	transport mailer.Mailer
+	driver    string
+	logger    *slog.Logger
+	config    Config
+}
+
+func New(outbox *data.OutboxModel, transport mailer.Mailer, driver string, logger *slog.Logger, config Config) *Worker {
+	return &Worker{
+		outbox:    outbox,
+		transport: transport,
+		driver:    driver,
+		logger:    logger,
+		config:    config,
+	}
+}
+
+// Run starts the workers and blocks until ctx is cancelled and every worker
+// finished the batch it was working on
+func (w *Worker) Run(ctx context.Context) {
+	workers.Run(ctx, w.config.Workers, w.config.PollInterval, func(ctx context.Context) bool {
+		// a full batch means more emails are probably waiting
+		return w.deliverBatch(ctx) == batchSize
+	})
+}
+
+// deliverBatch sends one batch of emails and returns how many it claimed
+func (w *Worker) deliverBatch(ctx context.Context) int {
+	emails, err := w.outbox.Claim(batchSize, lease)
+	if err != nil {
+		w.logger.Error("claiming emails", "error", err)
+		return 0
+	}
+
+	for i, email := range emails {
+		// hand unsent emails back right away instead of waiting for the lease
+		if ctx.Err() != nil {
+			for _, email := range emails[i:] {
+				err := w.outbox.Release(email)
+				if err != nil {
+					w.logger.Error("releasing email", "id", email.ID, "error", err)
+				}
+			}
+			break
+		}
+
+		w.deliver(email)
+	}
+
+	return len(emails)
+}
+
+func (w *Worker) deliver(email *data.OutboxEmail) {
+	sendErr := w.send(email)
+	if sendErr == nil {
+		err := w.outbox.MarkSent(email, w.driver)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			w.logger.Warn("lease ran out before the email was sent, it may go out twice", "id", email.ID)
+		case err != nil:
+			w.logger.Error("marking email sent", "id", email.ID, "error", err)
+		}
+		return
+	}
+
+	retryAfter := data.RetryDelay(email.Attempts, w.config.BaseDelay, w.config.MaxDelay)
+	err := w.outbox.MarkFailed(email, w.driver, sendErr, w.config.MaxAttempts, retryAfter)
+	if err != nil {
+		if errors.Is(err, data.ErrEditConflict) {
+			w.logger.Warn("lease ran out before the email was tried, another worker has it", "id", email.ID, "error", sendErr)
+			return
+		}
+		w.logger.Error("marking email failed", "id", email.ID, "error", err)
+		return
+	}
+
+	if email.Attempts >= w.config.MaxAttempts {
+		w.logger.Error("email is dead", "id", email.ID, "template", email.Template, "attempts", email.Attempts, "error", sendErr)
+		return
+	}
+	w.logger.Warn("email failed, retrying", "id", email.ID, "template", email.Template, "attempts", email.Attempts, "retry_after", retryAfter, "error", sendErr)
+}
+
+func (w *Worker) send(email *data.OutboxEmail) error {
+	// numbers stay as they were written, a user id shouldn't turn into 1e+06
+	var templateData map[string]any
+	dec := json.NewDecoder(bytes.NewReader(email.Data))
+	dec.UseNumber()
+	err := dec.Decode(&templateData)
+	if err != nil {
+		return err
+	}
+
+	return w.transport.Send(email.Recipient, email.Template, templateData, email.Attachments...)
+}
@@ -0,0 +1,72 @@
+// Package reminders emails customers ahead of their confirmed appointments
+package reminders
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/data"
+	"github.com/Lee26Ed/lockit_appointments/cmd/internal/workers"
+)
+
+// batchSize is how many reminders are queued in one transaction
+const batchSize = 50
+
+// timeLayout is how the appointment time reads in the reminder, in the time
+// zone of the business
+const timeLayout = "Monday, January 2 2006 at 15:04 MST"
+
+// Scheduler looks for appointments that are due for a reminder every
+// interval. Every API instance runs one, the reminder model makes sure each
+// reminder is only sent once.
+type Scheduler struct {
+	reminders *data.ReminderModel
+	logger    *slog.Logger
+	interval  time.Duration
+}
+
+func New(reminders *data.ReminderModel, logger *slog.Logger, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		reminders: reminders,
+		logger:    logger,
+		interval:  interval,
+	}
+}
+
+// Run blocks until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	workers.Run(ctx, 1, s.interval, s.sendBatch)
+}
+
+// sendBatch queues one batch of reminders and reports whether more are
+// probably due
+func (s *Scheduler) sendBatch(ctx context.Context) bool {
+	sent, err := s.reminders.SendDue(batchSize, email)
+	if err != nil {
+		s.logger.Error("sending reminders", "error", err)
+		return false
+	}
+
+	if sent > 0 {
+		s.logger.Info("reminders queued", "count", sent)
+	}
+
+	return sent == batchSize
+}
+
+// email builds the reminder for the customer
+func email(reminder *data.DueReminder) *data.QueuedEmail {
+	appointment := reminder.Appointment
+
+	return &data.QueuedEmail{
+		Recipient: appointment.CustomerEmail,
+		Template:  "appointment_reminder.tmpl",
+		Data: map[string]any{
+			"username":     appointment.CustomerName,
+			"businessName": appointment.BusinessName,
+			"serviceName":  appointment.ServiceName,
+			"startTime":    appointment.StartTime.In(appointment.Location()).Format(timeLayout),
+		},
+	}
+}
@@ -0,0 +1,214 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors,
+// "12345678901234567890" in base-32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// RFC 6238 Appendix B lists 8 digit codes, 6 digit codes are their last
+	// 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.want[len(tt.want)-Digits:]; got != want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestCodeSecretFormat(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	want, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// authenticator apps show secrets in lower case groups, sometimes padded
+	for _, secret := range []string{
+		strings.ToLower(rfcSecret),
+		"GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ",
+		rfcSecret + "====",
+	} {
+		got, err := Code(secret, now)
+		if err != nil {
+			t.Errorf("Code(%q): %v", secret, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Code(%q) = %s, want %s", secret, got, want)
+		}
+	}
+
+	if _, err := Code("not base32!", now); err == nil {
+		t.Error("Code accepted an invalid secret")
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	issued := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, issued)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		ok   bool
+	}{
+		{"same period", issued, true},
+		{"one period later", issued.Add(Period), true},
+		{"one period earlier", issued.Add(-Period), true},
+		{"two periods later", issued.Add(2 * Period), false},
+		{"two periods earlier", issued.Add(-2 * Period), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, code, tt.at)
+			if ok != tt.ok {
+				t.Fatalf("Validate = %v, want %v", ok, tt.ok)
+			}
+			if ok && step != Step(issued) {
+				t.Errorf("Validate matched step %d, want %d", step, Step(issued))
+			}
+		})
+	}
+}
+
+func TestValidateMalformed(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"empty code", rfcSecret, ""},
+		{"short code", rfcSecret, "05047"},
+		{"long code", rfcSecret, "14050471"},
+		{"letters", rfcSecret, "abcdef"},
+		{"invalid secret", "not base32!", "050471"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret, tt.code, now); ok {
+				t.Errorf("Validate(%q, %q) accepted", tt.secret, tt.code)
+			}
+		})
+	}
+}
+
+func TestValidateOnce(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := ValidateOnce(rfcSecret, code, now, 0)
+	if !ok {
+		t.Fatal("first use rejected")
+	}
+
+	// the same code is refused once its step was used, even while it is
+	// still inside the window
+	if _, ok := ValidateOnce(rfcSecret, code, now, step); ok {
+		t.Error("replayed code accepted")
+	}
+	if _, ok := ValidateOnce(rfcSecret, code, now.Add(Period), step); ok {
+		t.Error("replayed code accepted in the next period")
+	}
+
+	// an older code can't be used after a newer one
+	previous, err := Code(rfcSecret, now.Add(-Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ValidateOnce(rfcSecret, previous, now, step); ok {
+		t.Error("code older than the last used one accepted")
+	}
+
+	// the next code is fine
+	next, err := Code(rfcSecret, now.Add(Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := ValidateOnce(rfcSecret, next, now.Add(Period), step); !ok || got != step+1 {
+		t.Errorf("ValidateOnce(next) = %d, %v, want %d, true", got, ok, step+1)
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret is %d bytes, want 20", len(key))
+	}
+
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == secret {
+		t.Error("GenerateSecret returned the same secret twice")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Lockit", "jane@example.com", rfcSecret)
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		t.Errorf("URI = %s, want otpauth://totp/...", uri)
+	}
+	if u.Path != "/Lockit:jane@example.com" {
+		t.Errorf("label = %q, want %q", u.Path, "/Lockit:jane@example.com")
+	}
+
+	want := map[string]string{
+		"secret":    rfcSecret,
+		"issuer":    "Lockit",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	}
+	for key, value := range want {
+		if got := u.Query().Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Package workers runs the polling loops of the background queues
+package workers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WorkFunc does one round of work and reports whether more is probably
+// waiting, in which case it is called again right away
+type WorkFunc func(ctx context.Context) bool
+
+// Run calls work from count goroutines. Workers that found nothing to do wait
+// interval before they look again. Run blocks until ctx is cancelled and
+// every worker finished the round it was in.
+func Run(ctx context.Context, count int, interval time.Duration, work WorkFunc) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < max(count, 1); i++ {
+		wg.Go(func() {
+			loop(ctx, interval, work)
+		})
+	}
+
+	wg.Wait()
+}
+
+func loop(ctx context.Context, interval time.Duration, work WorkFunc) {
+	for {
+		if work(ctx) {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,5 @@
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_no_overlap;
+
+ALTER TABLE appointments
+ALTER COLUMN start_time TYPE TIMESTAMP USING start_time AT TIME ZONE 'UTC',
+ALTER COLUMN end_time TYPE TIMESTAMP USING end_time AT TIME ZONE 'UTC';
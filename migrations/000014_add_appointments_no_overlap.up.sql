@@ -0,0 +1,15 @@
+-- btree_gist lets the plain business_id column take part in a GiST index
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+-- tstzrange() only builds an immutable index expression from timestamptz values
+ALTER TABLE appointments
+ALTER COLUMN start_time TYPE TIMESTAMPTZ USING start_time AT TIME ZONE 'UTC',
+ALTER COLUMN end_time TYPE TIMESTAMPTZ USING end_time AT TIME ZONE 'UTC';
+
+-- two live appointments of the same business can't overlap
+ALTER TABLE appointments
+ADD CONSTRAINT appointments_no_overlap
+EXCLUDE USING gist (
+  business_id WITH =,
+  tstzrange(start_time, end_time) WITH &&
+) WHERE (status <> 'cancelled');
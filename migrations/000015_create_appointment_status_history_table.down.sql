@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_appointment_status_history_appointment_id;
+DROP TABLE IF EXISTS appointment_status_history;
@@ -0,0 +1,12 @@
+CREATE TABLE appointment_status_history (
+  id SERIAL PRIMARY KEY,
+  appointment_id INT NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+
+  from_status appointment_status NOT NULL,
+  to_status appointment_status NOT NULL,
+
+  changed_by INT REFERENCES users(id) ON DELETE SET NULL,
+  changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_appointment_status_history_appointment_id ON appointment_status_history(appointment_id);
@@ -0,0 +1,9 @@
+ALTER TABLE business_hours DROP CONSTRAINT IF EXISTS business_hours_business_id_fkey;
+
+ALTER TABLE business_hours
+ADD CONSTRAINT business_hours_business_id_fkey
+FOREIGN KEY (business_id) REFERENCES businesses(id);
+
+ALTER TABLE business_hours
+DROP CONSTRAINT IF EXISTS business_hours_range_check,
+DROP CONSTRAINT IF EXISTS business_hours_day_of_week_check;
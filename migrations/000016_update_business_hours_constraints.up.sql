@@ -0,0 +1,9 @@
+ALTER TABLE business_hours
+ADD CONSTRAINT business_hours_day_of_week_check CHECK (day_of_week BETWEEN 0 AND 6),
+ADD CONSTRAINT business_hours_range_check CHECK (start_time < end_time);
+
+ALTER TABLE business_hours DROP CONSTRAINT IF EXISTS business_hours_business_id_fkey;
+
+ALTER TABLE business_hours
+ADD CONSTRAINT business_hours_business_id_fkey
+FOREIGN KEY (business_id) REFERENCES businesses(id) ON DELETE CASCADE;
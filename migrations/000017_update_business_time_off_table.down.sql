@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS idx_business_time_off_business_id;
+
+ALTER TABLE business_time_off DROP CONSTRAINT IF EXISTS business_time_off_business_id_fkey;
+
+ALTER TABLE business_time_off
+ADD CONSTRAINT business_time_off_business_id_fkey
+FOREIGN KEY (business_id) REFERENCES businesses(id);
+
+ALTER TABLE business_time_off
+DROP CONSTRAINT IF EXISTS business_time_off_range_check,
+DROP COLUMN IF EXISTS created_at,
+ALTER COLUMN start_datetime DROP NOT NULL,
+ALTER COLUMN end_datetime DROP NOT NULL,
+ALTER COLUMN start_datetime TYPE TIMESTAMP USING start_datetime AT TIME ZONE 'UTC',
+ALTER COLUMN end_datetime TYPE TIMESTAMP USING end_datetime AT TIME ZONE 'UTC';
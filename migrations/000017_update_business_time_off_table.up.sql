@@ -0,0 +1,15 @@
+ALTER TABLE business_time_off
+ALTER COLUMN start_datetime TYPE TIMESTAMPTZ USING start_datetime AT TIME ZONE 'UTC',
+ALTER COLUMN end_datetime TYPE TIMESTAMPTZ USING end_datetime AT TIME ZONE 'UTC',
+ALTER COLUMN start_datetime SET NOT NULL,
+ALTER COLUMN end_datetime SET NOT NULL,
+ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+ADD CONSTRAINT business_time_off_range_check CHECK (start_datetime < end_datetime);
+
+ALTER TABLE business_time_off DROP CONSTRAINT IF EXISTS business_time_off_business_id_fkey;
+
+ALTER TABLE business_time_off
+ADD CONSTRAINT business_time_off_business_id_fkey
+FOREIGN KEY (business_id) REFERENCES businesses(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_business_time_off_business_id ON business_time_off(business_id);
@@ -0,0 +1,15 @@
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_no_overlap;
+
+ALTER TABLE appointments
+ADD CONSTRAINT appointments_no_overlap
+EXCLUDE USING gist (
+  business_id WITH =,
+  tstzrange(start_time, end_time) WITH &&
+) WHERE (status <> 'cancelled');
+
+ALTER TABLE appointments DROP COLUMN IF EXISTS business_staff_id;
+
+DROP TABLE IF EXISTS staff_services;
+DROP TRIGGER IF EXISTS set_business_staff_updated_at ON business_staff;
+DROP INDEX IF EXISTS idx_business_staff_business_id;
+DROP TABLE IF EXISTS business_staff;
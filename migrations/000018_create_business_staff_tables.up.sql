@@ -0,0 +1,42 @@
+CREATE TABLE business_staff (
+  id SERIAL PRIMARY KEY,
+  business_id INT NOT NULL REFERENCES businesses(id) ON DELETE CASCADE,
+
+  name VARCHAR(200) NOT NULL,
+  email VARCHAR(255),
+  phone VARCHAR(25),
+
+  active BOOLEAN NOT NULL DEFAULT TRUE,
+
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_business_staff_business_id ON business_staff(business_id);
+
+CREATE TRIGGER set_business_staff_updated_at
+BEFORE UPDATE ON business_staff
+FOR EACH ROW
+EXECUTE FUNCTION set_updated_at();
+
+-- the services each staff member can perform
+CREATE TABLE staff_services (
+  staff_id INT NOT NULL REFERENCES business_staff(id) ON DELETE CASCADE,
+  service_id INT NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+  PRIMARY KEY (staff_id, service_id)
+);
+
+ALTER TABLE appointments
+ADD COLUMN business_staff_id INT REFERENCES business_staff(id) ON DELETE SET NULL;
+
+-- staff members each have their own calendar, appointments without a staff
+-- member share the business calendar
+ALTER TABLE appointments DROP CONSTRAINT IF EXISTS appointments_no_overlap;
+
+ALTER TABLE appointments
+ADD CONSTRAINT appointments_no_overlap
+EXCLUDE USING gist (
+  business_id WITH =,
+  (COALESCE(business_staff_id, 0)) WITH =,
+  tstzrange(start_time, end_time) WITH &&
+) WHERE (status <> 'cancelled');
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_staff_time_off_staff_id;
+DROP TABLE IF EXISTS staff_time_off;
+
+DROP INDEX IF EXISTS idx_staff_schedule_staff_id;
+DROP TABLE IF EXISTS staff_schedule;
@@ -0,0 +1,31 @@
+-- the weekly working hours of a staff member, staff members without any
+-- hours work whenever the business is open
+CREATE TABLE staff_schedule (
+  id SERIAL PRIMARY KEY,
+  staff_id INT NOT NULL REFERENCES business_staff(id) ON DELETE CASCADE,
+
+  day_of_week INT NOT NULL CHECK (day_of_week BETWEEN 0 AND 6),
+  start_time TIME NOT NULL,
+  end_time TIME NOT NULL,
+
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  CONSTRAINT staff_schedule_range_check CHECK (start_time < end_time)
+);
+
+CREATE INDEX IF NOT EXISTS idx_staff_schedule_staff_id ON staff_schedule(staff_id);
+
+CREATE TABLE staff_time_off (
+  id SERIAL PRIMARY KEY,
+  staff_id INT NOT NULL REFERENCES business_staff(id) ON DELETE CASCADE,
+
+  start_datetime TIMESTAMPTZ NOT NULL,
+  end_datetime TIMESTAMPTZ NOT NULL,
+  reason TEXT,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+  CONSTRAINT staff_time_off_range_check CHECK (start_datetime < end_datetime)
+);
+
+CREATE INDEX IF NOT EXISTS idx_staff_time_off_staff_id ON staff_time_off(staff_id);
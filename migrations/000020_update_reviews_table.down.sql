@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS idx_reviews_business_id;
+
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_appointment_id_fkey;
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_business_id_fkey;
+
+ALTER TABLE reviews
+ADD CONSTRAINT reviews_appointment_id_fkey
+FOREIGN KEY (appointment_id) REFERENCES appointments(id),
+ADD CONSTRAINT reviews_business_id_fkey
+FOREIGN KEY (business_id) REFERENCES businesses(id);
+
+ALTER TABLE reviews
+ALTER COLUMN appointment_id DROP NOT NULL,
+ALTER COLUMN business_id DROP NOT NULL,
+ALTER COLUMN created_at DROP NOT NULL;
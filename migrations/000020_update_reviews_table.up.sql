@@ -0,0 +1,15 @@
+ALTER TABLE reviews
+ALTER COLUMN appointment_id SET NOT NULL,
+ALTER COLUMN business_id SET NOT NULL,
+ALTER COLUMN created_at SET NOT NULL;
+
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_appointment_id_fkey;
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_business_id_fkey;
+
+ALTER TABLE reviews
+ADD CONSTRAINT reviews_appointment_id_fkey
+FOREIGN KEY (appointment_id) REFERENCES appointments(id) ON DELETE CASCADE,
+ADD CONSTRAINT reviews_business_id_fkey
+FOREIGN KEY (business_id) REFERENCES businesses(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_reviews_business_id ON reviews(business_id);
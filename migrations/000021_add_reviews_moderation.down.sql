@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_reviews_state;
+
+ALTER TABLE reviews
+DROP CONSTRAINT IF EXISTS reviews_state_check,
+DROP COLUMN IF EXISTS moderated_at,
+DROP COLUMN IF EXISTS flagged_at,
+DROP COLUMN IF EXISTS flagged_by,
+DROP COLUMN IF EXISTS flag_reason,
+DROP COLUMN IF EXISTS replied_at,
+DROP COLUMN IF EXISTS reply,
+DROP COLUMN IF EXISTS state;
@@ -0,0 +1,11 @@
+ALTER TABLE reviews
+ADD COLUMN state VARCHAR(20) NOT NULL DEFAULT 'visible',
+ADD COLUMN reply TEXT,
+ADD COLUMN replied_at TIMESTAMPTZ,
+ADD COLUMN flag_reason TEXT,
+ADD COLUMN flagged_by INT REFERENCES users(id) ON DELETE SET NULL,
+ADD COLUMN flagged_at TIMESTAMPTZ,
+ADD COLUMN moderated_at TIMESTAMPTZ,
+ADD CONSTRAINT reviews_state_check CHECK (state IN ('visible', 'flagged', 'hidden'));
+
+CREATE INDEX IF NOT EXISTS idx_reviews_state ON reviews(state);
@@ -0,0 +1,3 @@
+ALTER TABLE auth_tokens
+ADD COLUMN user_agent TEXT,
+ADD COLUMN ip_address VARCHAR(45);
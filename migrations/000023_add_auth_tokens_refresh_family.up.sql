@@ -0,0 +1,7 @@
+-- tokens issued from the same sign in share a family, so a stolen refresh
+-- token can be revoked together with everything issued from it
+ALTER TABLE auth_tokens
+ADD COLUMN family_id VARCHAR(32),
+ADD COLUMN used_at TIMESTAMP(0) WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_auth_tokens_family_id ON auth_tokens(family_id);
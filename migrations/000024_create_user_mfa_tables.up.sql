@@ -0,0 +1,23 @@
+CREATE TABLE user_mfa (
+  user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+
+  secret TEXT NOT NULL,
+  -- NULL until the user proves their authenticator app works
+  enabled_at TIMESTAMPTZ,
+  -- the last TOTP step accepted, so a code can't be used twice
+  last_used_step BIGINT NOT NULL DEFAULT 0,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE user_mfa_recovery_codes (
+  id SERIAL PRIMARY KEY,
+  user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+
+  code_hash BYTEA NOT NULL,
+  used_at TIMESTAMPTZ,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_mfa_recovery_codes_user_id ON user_mfa_recovery_codes(user_id);
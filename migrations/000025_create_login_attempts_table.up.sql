@@ -0,0 +1,13 @@
+-- failed sign ins counted per username and per IP address
+CREATE TABLE login_attempts (
+  key_type VARCHAR(10) NOT NULL CHECK (key_type IN ('username', 'ip')),
+  key VARCHAR(255) NOT NULL,
+
+  failures INT NOT NULL DEFAULT 0,
+  last_failure_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+  PRIMARY KEY (key_type, key)
+);
+
+ALTER TABLE users
+ADD COLUMN locked_until TIMESTAMPTZ;
@@ -0,0 +1,7 @@
+ALTER TABLE businesses
+DROP COLUMN IF EXISTS suspended_at,
+DROP COLUMN IF EXISTS suspended_reason;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS suspended_at,
+DROP COLUMN IF EXISTS suspended_reason;
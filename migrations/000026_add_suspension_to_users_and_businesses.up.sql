@@ -0,0 +1,8 @@
+-- why and when an admin suspended the account, cleared on reinstatement
+ALTER TABLE users
+ADD COLUMN suspended_reason TEXT,
+ADD COLUMN suspended_at TIMESTAMPTZ;
+
+ALTER TABLE businesses
+ADD COLUMN suspended_reason TEXT,
+ADD COLUMN suspended_at TIMESTAMPTZ;
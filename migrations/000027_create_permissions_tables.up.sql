@@ -0,0 +1,43 @@
+-- the built-in roles, in case the seed data was never loaded
+INSERT INTO roles (id, role) VALUES
+(1, 'admin'),
+(2, 'business'),
+(3, 'user')
+ON CONFLICT (id) DO NOTHING;
+
+-- the roles above were inserted with explicit ids, move the sequence past
+-- them so roles created through the API get a free id
+SELECT setval(pg_get_serial_sequence('roles', 'id'), (SELECT MAX(id) FROM roles));
+
+ALTER TABLE roles
+ADD CONSTRAINT roles_role_key UNIQUE (role);
+
+CREATE TABLE permissions (
+  id SERIAL PRIMARY KEY,
+  code VARCHAR(100) NOT NULL UNIQUE
+);
+
+CREATE TABLE role_permissions (
+  role_id INT NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+  permission_id INT NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+  PRIMARY KEY (role_id, permission_id)
+);
+
+INSERT INTO permissions (code) VALUES
+('users:read'),
+('users:manage'),
+('roles:manage'),
+('businesses:moderate'),
+('services:write'),
+('appointments:write'),
+('reviews:moderate');
+
+-- admins can do everything
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT 1, id FROM permissions;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT 2, id FROM permissions WHERE code IN ('services:write', 'appointments:write');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT 3, id FROM permissions WHERE code IN ('appointments:write');
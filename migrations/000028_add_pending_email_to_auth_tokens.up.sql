@@ -0,0 +1,3 @@
+-- the new address an email-change token confirms
+ALTER TABLE auth_tokens
+ADD COLUMN pending_email VARCHAR(255);
@@ -0,0 +1,12 @@
+-- every email the application tried to send and how it went
+CREATE TABLE email_outbox (
+  id BIGSERIAL PRIMARY KEY,
+  recipient VARCHAR(255) NOT NULL,
+  template VARCHAR(100) NOT NULL,
+  driver VARCHAR(20) NOT NULL,
+  status VARCHAR(20) NOT NULL CHECK (status IN ('sent', 'failed')),
+  error TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX email_outbox_status_created_at_idx ON email_outbox (status, created_at);
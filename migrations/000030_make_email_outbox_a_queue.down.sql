@@ -0,0 +1,23 @@
+DELETE FROM permissions WHERE code = 'emails:manage';
+
+DROP INDEX IF EXISTS email_outbox_pending_idx;
+
+ALTER TABLE email_outbox
+DROP CONSTRAINT email_outbox_status_check;
+
+-- anything that never got delivered counts as failed
+UPDATE email_outbox SET status = 'failed' WHERE status <> 'sent';
+UPDATE email_outbox SET driver = '' WHERE driver IS NULL;
+
+ALTER TABLE email_outbox
+DROP COLUMN IF EXISTS updated_at,
+DROP COLUMN IF EXISTS sent_at,
+DROP COLUMN IF EXISTS locked_until,
+DROP COLUMN IF EXISTS next_attempt_at,
+DROP COLUMN IF EXISTS attempts,
+DROP COLUMN IF EXISTS data,
+ALTER COLUMN status DROP DEFAULT,
+ALTER COLUMN driver SET NOT NULL,
+ADD CONSTRAINT email_outbox_status_check CHECK (status IN ('sent', 'failed'));
+
+ALTER TABLE email_outbox RENAME COLUMN last_error TO error;
@@ -0,0 +1,29 @@
+-- emails are now queued first and delivered by the mail workers
+ALTER TABLE email_outbox
+DROP CONSTRAINT email_outbox_status_check;
+
+UPDATE email_outbox SET status = 'dead' WHERE status = 'failed';
+
+ALTER TABLE email_outbox RENAME COLUMN error TO last_error;
+
+ALTER TABLE email_outbox
+ALTER COLUMN driver DROP NOT NULL,
+ALTER COLUMN status SET DEFAULT 'queued',
+ADD COLUMN data JSONB NOT NULL DEFAULT '{}',
+ADD COLUMN attempts INT NOT NULL DEFAULT 0,
+ADD COLUMN next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+ADD COLUMN locked_until TIMESTAMPTZ,
+ADD COLUMN sent_at TIMESTAMPTZ,
+ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+ADD CONSTRAINT email_outbox_status_check CHECK (status IN ('queued', 'sending', 'sent', 'dead'));
+
+UPDATE email_outbox SET sent_at = created_at WHERE status = 'sent';
+
+-- what the workers look at when they poll for work
+CREATE INDEX email_outbox_pending_idx ON email_outbox (next_attempt_at)
+WHERE status IN ('queued', 'sending');
+
+INSERT INTO permissions (code) VALUES ('emails:manage');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT 1, id FROM permissions WHERE code = 'emails:manage';
@@ -0,0 +1,4 @@
+ALTER TABLE appointments
+DROP CONSTRAINT IF EXISTS appointments_uid_key,
+DROP COLUMN IF EXISTS calendar_sequence,
+DROP COLUMN IF EXISTS uid;
@@ -0,0 +1,6 @@
+-- calendar invites identify an appointment by its uid, the sequence goes up
+-- with every change so calendars know which invite is the newest
+ALTER TABLE appointments
+ADD COLUMN uid TEXT NOT NULL DEFAULT gen_random_uuid()::text,
+ADD COLUMN calendar_sequence INT NOT NULL DEFAULT 0,
+ADD CONSTRAINT appointments_uid_key UNIQUE (uid);
@@ -0,0 +1,2 @@
+ALTER TABLE email_outbox
+ADD COLUMN attachments JSONB NOT NULL DEFAULT '[]';
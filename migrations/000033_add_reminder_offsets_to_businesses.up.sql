@@ -0,0 +1,3 @@
+-- how many hours before a confirmed appointment the customer gets a reminder
+ALTER TABLE businesses
+ADD COLUMN reminder_offsets INT[] NOT NULL DEFAULT '{24,2}';
@@ -0,0 +1,13 @@
+-- one row per reminder sent, so no reminder goes out twice
+CREATE TABLE appointment_reminders (
+  appointment_id INT NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+  offset_hours INT NOT NULL,
+
+  sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+  PRIMARY KEY (appointment_id, offset_hours)
+);
+
+-- what the reminder scheduler looks at
+CREATE INDEX appointments_confirmed_start_time_idx ON appointments (start_time)
+WHERE status = 'confirmed';
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'jobs:read';
+
+DROP TABLE IF EXISTS jobs;
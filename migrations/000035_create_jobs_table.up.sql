@@ -0,0 +1,33 @@
+-- background work picked up by the job runner. public_id is what clients
+-- get to check on a job, the serial id stays internal
+CREATE TABLE jobs (
+  id BIGSERIAL PRIMARY KEY,
+  public_id UUID NOT NULL DEFAULT gen_random_uuid() UNIQUE,
+
+  kind VARCHAR(100) NOT NULL,
+  payload JSONB NOT NULL DEFAULT '{}',
+
+  status VARCHAR(20) NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+  progress INT NOT NULL DEFAULT 0 CHECK (progress BETWEEN 0 AND 100),
+
+  attempts INT NOT NULL DEFAULT 0,
+  max_attempts INT NOT NULL DEFAULT 5,
+  last_error TEXT,
+
+  run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  locked_until TIMESTAMPTZ,
+
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  finished_at TIMESTAMPTZ
+);
+
+-- what the runners look at when they poll for work
+CREATE INDEX jobs_pending_idx ON jobs (run_at)
+WHERE status IN ('pending', 'running');
+
+INSERT INTO permissions (code) VALUES ('jobs:read');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT 1, id FROM permissions WHERE code = 'jobs:read';
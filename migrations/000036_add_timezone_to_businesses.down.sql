@@ -0,0 +1,2 @@
+ALTER TABLE businesses
+DROP COLUMN IF EXISTS timezone;
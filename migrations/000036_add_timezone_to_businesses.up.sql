@@ -0,0 +1,3 @@
+-- the IANA time zone the opening hours of the business are in
+ALTER TABLE businesses
+ADD COLUMN timezone TEXT NOT NULL DEFAULT 'UTC';
@@ -0,0 +1,6 @@
+ALTER TABLE appointments
+DROP CONSTRAINT IF EXISTS appointments_business_staff_id_fkey;
+
+ALTER TABLE appointments
+ADD CONSTRAINT appointments_business_staff_id_fkey
+FOREIGN KEY (business_staff_id) REFERENCES business_staff(id) ON DELETE SET NULL;
@@ -0,0 +1,8 @@
+-- staff members are deactivated rather than deleted, deleting one must not
+-- move their appointments onto the business calendar
+ALTER TABLE appointments
+DROP CONSTRAINT IF EXISTS appointments_business_staff_id_fkey;
+
+ALTER TABLE appointments
+ADD CONSTRAINT appointments_business_staff_id_fkey
+FOREIGN KEY (business_staff_id) REFERENCES business_staff(id);
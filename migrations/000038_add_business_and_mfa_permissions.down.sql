@@ -0,0 +1 @@
+DELETE FROM permissions WHERE code IN ('businesses:manage', 'mfa:use');
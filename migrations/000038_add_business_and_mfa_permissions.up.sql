@@ -0,0 +1,11 @@
+-- admins used to be recognised by their role name, these permissions let
+-- custom roles do the same
+INSERT INTO permissions (code) VALUES
+('businesses:manage'),
+('mfa:use');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT 1, id FROM permissions WHERE code IN ('businesses:manage', 'mfa:use');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT 2, id FROM permissions WHERE code = 'mfa:use';
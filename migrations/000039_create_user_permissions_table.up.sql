@@ -0,0 +1,6 @@
+-- permissions granted to a single user on top of those of their role
+CREATE TABLE user_permissions (
+  user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  permission_id INT NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+  PRIMARY KEY (user_id, permission_id)
+);